@@ -7,12 +7,15 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
 	"github.com/neil-peng/gomvc/utils"
 )
 
 type Api struct {
-	ctx *utils.Context
+	ctx   *utils.Context
+	span  *utils.Span //请求的根span
+	errno string
 }
 
 //api处理流程
@@ -32,7 +35,17 @@ func (a *Api) Execute(ctx *gin.Context, cb utils.ApiCb) {
 	}
 
 	//step3:api逻辑回调（可能会抛出panic，通过error处理结果，未定义异常会出panic）
-	if err := cb(a.ctx); err != nil {
+	err := cb(a.ctx)
+	if err != nil && a.ctx.Canceled() {
+		a.ctx.Warn("api canceled, err:%v, ctx err:%v", err, a.ctx.StdContext().Err())
+		err = apperr.Wrap(err, conf.ERROR_TIMEOUT)
+	}
+
+	//step4:结束请求内未结束的事务，回调失败则回滚
+	if txErr := a.ctx.EndTransactions(err); err == nil {
+		err = txErr
+	}
+	if err != nil {
 		a.fail(err)
 	} else {
 		a.success()
@@ -48,13 +61,26 @@ func (a *Api) newContext(ctx *gin.Context) error {
 	clientIp := a.ctx.ClientIP()
 	hostIp, _ := utils.GetHostIp()
 
-	a.ctx.SetNameService(&utils.IpServer)
+	a.ctx.SetTimeout(ctx.GetDuration(conf.API_TIMEOUT))
+	a.ctx.SetNameService(utils.DefaultNameService())
 	a.ctx.Set(conf.CLIENT_IP, clientIp)
 	a.ctx.Set(conf.SERVER_IP, hostIp)
 	a.ctx.Set(conf.API_TIME, time.Now())
 
 	a.ctx.SetBaseInfo("logid", a.ctx.LogId())
 	a.ctx.PushNotice("client", clientIp)
+
+	//沿用上游的traceparent，未经路由时以请求path命名
+	route := ctx.GetString(conf.API_ROUTE)
+	if len(route) == 0 {
+		route = ctx.Request.URL.Path
+	}
+	a.span = a.ctx.StartTrace(ctx.Request.Method+" "+route, ctx.Request.Header)
+	a.span.SetAttr("http.method", ctx.Request.Method)
+	a.span.SetAttr("http.route", route)
+	a.span.SetAttr("client.address", clientIp)
+	a.span.SetAttr("logid", a.ctx.LogId())
+	a.ctx.PushNotice("trace_id", a.ctx.TraceId())
 	return nil
 }
 
@@ -70,42 +96,70 @@ func (a *Api) success() {
 	a.ctx.SetResponseBody(conf.ERR_MSG, "success")
 	a.ctx.Status(200)
 
-	a.ctx.PushNotice(conf.HTTP_CODE, "200")
+	a.errno = "0"
 	a.ctx.PushNotice(conf.ERR_MSG, "success")
-	a.ctx.PushNotice(conf.ERR_CODE, "0")
 }
 
 func (a *Api) fail(err error) {
-	errMsg := conf.GetHttpMsg(err.Error())
-	a.ctx.Set("status", conf.GetHttpCode(err.Error()))
+	appErr := apperr.From(err)
+	//内部原因只打日志
+	if msg := appErr.Error(); msg != appErr.Errno {
+		a.ctx.Warn("api fail, err:%s", msg)
+	}
+	errMsg := appErr.Localize(a.langs()...)
+	a.ctx.Set("status", appErr.Status)
 	a.ctx.SetResponseBody(conf.ERR_MSG, errMsg)
-	a.ctx.SetResponseBody(conf.ERR_CODE, err.Error())
+	a.ctx.SetResponseBody(conf.ERR_CODE, appErr.Errno)
 	a.ctx.SetResponseBody("log_id", a.ctx.LogId())
+	//参数校验等错误附带的明细，例：各字段的错误
+	var detail interface{ Details() interface{} }
+	if errors.As(err, &detail) {
+		a.ctx.SetResponseBody(conf.ERR_DETAILS, detail.Details())
+	}
 
-	a.ctx.PushNotice(conf.HTTP_CODE, conf.GetHttpCode(err.Error()))
+	a.errno = appErr.Errno
 	a.ctx.PushNotice(conf.ERR_MSG, errMsg)
-	a.ctx.PushNotice(conf.ERR_CODE, err.Error())
+}
+
+//错误信息的语言：lang参数优先，其次Accept-Language
+func (a *Api) langs() []string {
+	langs := apperr.ParseAcceptLanguage(a.ctx.GetHeader("Accept-Language"))
+	if lang := a.ctx.Query("lang"); len(lang) > 0 {
+		langs = append([]string{lang}, langs...)
+	}
+	return langs
 }
 
 func (a *Api) finish() {
+	defer a.ctx.Cancel()
 	if r := recover(); r != nil {
 		//非预期的异常全部转化成特性错误错误，501
 		a.ctx.Critical("panic err:%v, stacktrace:%s", r, string(debug.Stack()))
-		a.fail(errors.New(conf.ERROR_NETWORK_ERROR))
+		a.ctx.EndTransactions(fmt.Errorf("panic:%v", r))
+		a.fail(apperr.Errorf(conf.ERROR_NETWORK_ERROR, "panic:%v", r))
 	}
 
 	//填写返回的header
 	for k, v := range a.ctx.ListResponseHeader() {
 		a.ctx.Header(k, v)
 	}
-	apiResult := a.ctx.GetJsonResponseBody()
-	a.ctx.Info("api return:%s", apiResult)
-	a.ctx.Data(a.ctx.GetInt("status"), "text/html;charset=utf8", apiResult)
+	if utils.GetLogLevel() >= utils.LEVEL_INFO {
+		a.ctx.Info("api return:%s", a.ctx.GetJsonResponseBody())
+	}
+	a.ctx.WriteResponse(a.ctx.GetInt("status"))
 	apiTime := a.ctx.MustGet(conf.API_TIME).(time.Time)
-	apiCost := time.Since(apiTime)
-	cost := fmt.Sprintf("%d", apiCost/1000/1000)
 	a.ctx.PushNotice("referer", a.ctx.GetHeader("Referer"))
-	a.ctx.Notice("[process_time:%sms]", cost)
+	latency := time.Since(apiTime)
+	a.ctx.Access(a.ctx.Request.Method, a.ctx.Request.URL.Path, a.ctx.Writer.Status(), a.errno, latency)
+	a.ctx.ObserveRequest(a.errno, latency)
+
+	a.span.SetAttr("http.status_code", a.ctx.Writer.Status())
+	a.span.SetAttr("errno", a.errno)
+	var spanErr error
+	if a.errno != conf.NO_ERROR {
+		spanErr = errors.New(a.errno)
+	}
+	a.span.End(spanErr)
 }
 
 func (a *Api) New() utils.ApiActor {
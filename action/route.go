@@ -0,0 +1,14 @@
+package action
+
+import (
+	"github.com/neil-peng/gomvc/utils"
+)
+
+//注册全部路由
+func Register(r *utils.Router) {
+	r.SetActor(&Api{})
+
+	example := r.Group("/rest/example")
+	example.GET("/add", AddExample)
+	example.GET("/get", GetExample)
+}
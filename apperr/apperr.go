@@ -0,0 +1,145 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+//错误码定义，通过Register注册
+type Code struct {
+	Errno     string //错误码，返回给调用方的error_code
+	Status    int    //http状态码
+	Msg       string //返回给调用方的error_msg
+	Retryable bool   //调用方是否可以重试
+}
+
+//带错误码的错误，cause为内部原因，只打日志，不返回给调用方；args为错误信息模板的参数
+type Error struct {
+	Code
+	cause error
+	args  map[string]interface{}
+}
+
+//未知错误转换成的错误码，由conf设置为ERROR_INNER_ERROR
+var InnerErrno = "10007"
+
+var (
+	codes   = map[string]Code{}
+	codesMu sync.RWMutex
+)
+
+//注册错误码，通常在各业务包的init中调用，错误码重复时panic
+func Register(code Code) {
+	codesMu.Lock()
+	defer codesMu.Unlock()
+	if _, ok := codes[code.Errno]; ok {
+		panic("apperr: errno " + code.Errno + " registered twice")
+	}
+	if code.Status == 0 {
+		code.Status = http.StatusInternalServerError
+	}
+	codes[code.Errno] = code
+}
+
+func Lookup(errno string) (Code, bool) {
+	codesMu.RLock()
+	defer codesMu.RUnlock()
+	code, ok := codes[errno]
+	return code, ok
+}
+
+//未注册的错误码按500返回
+func New(errno string) *Error {
+	code, ok := Lookup(errno)
+	if !ok {
+		code = Code{Errno: errno, Status: http.StatusInternalServerError, Msg: "unknow errno:" + errno}
+	}
+	return &Error{Code: code}
+}
+
+//以err为内部原因创建错误
+func Wrap(err error, errno string) *Error {
+	e := New(errno)
+	e.cause = err
+	return e
+}
+
+//以格式化的信息为内部原因创建错误
+func Errorf(errno string, format string, v ...interface{}) *Error {
+	return Wrap(fmt.Errorf(format, v...), errno)
+}
+
+//替换返回给调用方的信息，返回新的错误
+func (e *Error) WithMsg(msg string) *Error {
+	n := *e
+	n.Msg = msg
+	return &n
+}
+
+//设置错误信息模板的参数，返回新的错误，例：WithArgs(map[string]interface{}{"field": "user_id"})
+func (e *Error) WithArgs(args map[string]interface{}) *Error {
+	n := *e
+	n.args = args
+	return &n
+}
+
+//模板参数，未设置时取内部原因的Args()，例：参数校验错误的字段
+func (e *Error) Args() map[string]interface{} {
+	if e.args != nil {
+		return e.args
+	}
+	var argser interface{ Args() map[string]interface{} }
+	if e.cause != nil && errors.As(e.cause, &argser) {
+		return argser.Args()
+	}
+	return nil
+}
+
+//只有错误码时为错误码，有内部原因时为"错误码: 原因"
+func (e *Error) Error() string {
+	if e.cause == nil || e.cause.Error() == e.Errno {
+		return e.Errno
+	}
+	return e.Errno + ": " + e.cause.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+//错误码相同即视为同一错误，例：errors.Is(err, apperr.New(conf.ERROR_TIMEOUT))
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Errno == e.Errno
+}
+
+//转换为*Error：
+//错误链中有*Error时直接返回；Error()为已注册错误码的错误(如errors.New(conf.ERROR_PARAM_ERROR))按该错误码转换；
+//其他错误作为内部错误
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if _, ok := Lookup(err.Error()); ok {
+		return Wrap(err, err.Error())
+	}
+	return Wrap(err, InnerErrno)
+}
+
+//判断err是否为指定错误码
+func Is(err error, errno string) bool {
+	e := From(err)
+	return e != nil && e.Errno == errno
+}
+
+//判断err是否可以重试，非*Error按不可重试处理
+func IsRetryable(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Retryable
+}
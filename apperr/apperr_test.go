@@ -0,0 +1,46 @@
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func init() {
+	Register(Code{Errno: "20001", Status: 404, Msg: "user not found"})
+	Register(Code{Errno: "20002", Msg: "user busy", Retryable: true})
+}
+
+func TestError(t *testing.T) {
+	err := New("20001")
+	assert.Equal(t, err.Error(), "20001")
+	assert.Equal(t, err.Status, 404)
+	assert.Equal(t, err.Msg, "user not found")
+
+	cause := errors.New("no rows")
+	wrapped := fmt.Errorf("load user: %w", Wrap(cause, "20001"))
+	assert.Assert(t, errors.Is(wrapped, New("20001")))
+	assert.Assert(t, !errors.Is(wrapped, New("20002")))
+	assert.Assert(t, errors.Is(wrapped, cause))
+	assert.Equal(t, From(wrapped).Error(), "20001: no rows")
+	assert.Assert(t, Is(wrapped, "20001"))
+
+	assert.Equal(t, New("20002").Status, 500)
+	assert.Assert(t, IsRetryable(fmt.Errorf("call: %w", New("20002"))))
+	assert.Equal(t, New("20001").WithMsg("no such user").Msg, "no such user")
+	assert.Equal(t, New("29999").Msg, "unknow errno:29999")
+}
+
+func TestFrom(t *testing.T) {
+	//Error()为已注册错误码的旧式错误
+	e := From(errors.New("20001"))
+	assert.Equal(t, e.Errno, "20001")
+	assert.Equal(t, e.Error(), "20001")
+
+	e = From(errors.New("boom"))
+	assert.Equal(t, e.Errno, InnerErrno)
+	assert.Equal(t, e.Unwrap().Error(), "boom")
+	assert.Assert(t, From(nil) == nil)
+}
@@ -0,0 +1,161 @@
+package apperr
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+)
+
+//按语言的错误信息，key为错误码，value为text/template模板，参数见Error.Args，例：
+//"10006" = "param error{{with .field}}: {{.}}{{end}}"
+type catalog map[string]*template.Template
+
+var (
+	catalogs    = map[string]catalog{}
+	defaultLang = "en"
+	catalogsMu  sync.RWMutex
+)
+
+//加载目录下的错误信息文件，文件名为语言，例：en.toml、zh-CN.toml；目录不存在时不加载
+func LoadCatalogs(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.toml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		msgs := map[string]string{}
+		if _, err := toml.DecodeFile(file, &msgs); err != nil {
+			return err
+		}
+		lang := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if err := SetCatalog(lang, msgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//设置语言的错误信息，与已有的合并，业务包可以为自己的错误码补充翻译
+func SetCatalog(lang string, msgs map[string]string) error {
+	c := catalog{}
+	for errno, msg := range msgs {
+		tmpl, err := template.New(errno).Parse(msg)
+		if err != nil {
+			return err
+		}
+		c[errno] = tmpl
+	}
+
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+	lang = normalizeLang(lang)
+	if catalogs[lang] == nil {
+		catalogs[lang] = catalog{}
+	}
+	for errno, tmpl := range c {
+		catalogs[lang][errno] = tmpl
+	}
+	return nil
+}
+
+//请求语言都没有翻译时使用的语言
+func SetDefaultLang(lang string) {
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+	if len(lang) > 0 {
+		defaultLang = normalizeLang(lang)
+	}
+}
+
+//按langs的顺序取第一个有翻译的语言，每个语言依次回退到上级语言(zh-Hant-TW -> zh-Hant -> zh)，
+//最后使用默认语言，都没有时返回Msg
+func (e *Error) Localize(langs ...string) string {
+	catalogsMu.RLock()
+	var tmpl *template.Template
+	for _, lang := range fallbackLangs(langs) {
+		if tmpl = catalogs[lang][e.Errno]; tmpl != nil {
+			break
+		}
+	}
+	catalogsMu.RUnlock()
+	if tmpl == nil {
+		return e.Msg
+	}
+
+	args := e.Args()
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, args); err != nil {
+		return e.Msg
+	}
+	return buf.String()
+}
+
+//调用时持有catalogsMu
+func fallbackLangs(langs []string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, lang := range append(langs, defaultLang) {
+		for lang = normalizeLang(lang); len(lang) > 0; {
+			if !seen[lang] {
+				seen[lang] = true
+				result = append(result, lang)
+			}
+			i := strings.LastIndex(lang, "-")
+			if i < 0 {
+				break
+			}
+			lang = lang[:i]
+		}
+	}
+	return result
+}
+
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
+
+//按q值从高到低返回Accept-Language中的语言，例："zh-CN,zh;q=0.9,en;q=0.8"
+func ParseAcceptLanguage(header string) []string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+	var items []langQ
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.TrimSpace(fields[0])
+		if len(lang) == 0 || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			items = append(items, langQ{lang: lang, q: q})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].q > items[j].q
+	})
+	langs := make([]string, 0, len(items))
+	for _, item := range items {
+		langs = append(langs, item.lang)
+	}
+	return langs
+}
+
@@ -0,0 +1,45 @@
+package apperr
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+type fieldErr struct{}
+
+func (fieldErr) Error() string { return "20003" }
+
+func (fieldErr) Args() map[string]interface{} {
+	return map[string]interface{}{"field": "user_id"}
+}
+
+func TestLocalize(t *testing.T) {
+	Register(Code{Errno: "20003", Status: 400, Msg: "bad field"})
+	assert.NilError(t, SetCatalog("en", map[string]string{"20003": "bad field{{with .field}}: {{.}}{{end}}"}))
+	assert.NilError(t, SetCatalog("zh", map[string]string{"20003": "字段错误{{with .field}}：{{.}}{{end}}"}))
+	assert.NilError(t, SetCatalog("zh-TW", map[string]string{"20003": "欄位錯誤"}))
+
+	e := New("20003")
+	assert.Equal(t, e.Localize("zh-CN"), "字段错误")
+	assert.Equal(t, e.Localize("zh-Hant-TW"), "字段错误")
+	assert.Equal(t, e.Localize("zh-tw"), "欄位錯誤")
+	assert.Equal(t, e.Localize("fr", "de"), "bad field")
+	assert.Equal(t, e.WithArgs(map[string]interface{}{"field": "name"}).Localize(), "bad field: name")
+	assert.Equal(t, From(fieldErr{}).Localize("zh"), "字段错误：user_id")
+	//没有翻译时为注册的Msg
+	assert.Equal(t, New("20001").Localize("zh"), "user not found")
+}
+
+func TestLoadCatalogs(t *testing.T) {
+	assert.NilError(t, LoadCatalogs("../conf/i18n"))
+	Register(Code{Errno: "10006", Status: 400, Msg: "param error"})
+	assert.Equal(t, New("10006").Localize("zh-CN"), "参数错误")
+	assert.Equal(t, From(fieldErr{}).WithArgs(nil).Localize("en"), "bad field: user_id")
+	assert.NilError(t, LoadCatalogs("./not-exist"))
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.DeepEqual(t, ParseAcceptLanguage("en;q=0.8, zh-CN,zh;q=0.9,*;q=0.1,fr;q=0"), []string{"zh-CN", "zh", "en"})
+	assert.Equal(t, len(ParseAcceptLanguage("")), 0)
+}
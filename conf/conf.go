@@ -1,10 +1,10 @@
 package conf
 
 import (
-	"errors"
 	"os"
 
 	"github.com/BurntSushi/toml"
+	"github.com/neil-peng/gomvc/apperr"
 )
 
 type Conf_Api struct {
@@ -12,6 +12,61 @@ type Conf_Api struct {
 	LOG_LEVEL     int32
 	LOG_FILE_NAME string
 	LOG_FILE_DIR  string
+	LOG_FORMAT    string //text(默认)|json|logfmt
+	LOG_SINK      []Conf_Log_Sink
+
+	//访问日志文件，相对log目录，为空时写入主日志
+	ACCESS_LOG_FILE_NAME string
+
+	//单个日志文件超过LOG_ROTATE_SIZE_MB时切分，归档总大小超过LOG_MAX_TOTAL_MB时删除最旧的归档，0不限制
+	LOG_ROTATE_SIZE_MB int
+	LOG_MAX_TOTAL_MB   int
+	LOG_COMPRESS       bool
+
+	//异步日志队列长度，0同步写入；队列满时LOG_OVERFLOW：block(默认)|drop
+	LOG_ASYNC_BUFFER int
+	LOG_OVERFLOW     string
+
+	//请求处理时限，0不限制；ROUTE_TIMEOUT_MS按路由path覆盖
+	API_TIMEOUT_MS   int
+	ROUTE_TIMEOUT_MS map[string]int
+
+	//名字服务：ip(默认)|file|dns
+	NAME_SERVICE            string
+	NAME_SERVICE_FILE       string
+	NAME_SERVICE_REFRESH_MS int
+
+	//负载均衡：weighted(默认)|roundrobin|leastconn，连续失败MAX_FAILS次的实例摘除EJECT_MS
+	NAME_SERVICE_BALANCE   string
+	NAME_SERVICE_MAX_FAILS int
+	NAME_SERVICE_EJECT_MS  int
+
+	//错误信息翻译目录，相对conf目录，文件名为语言；请求未指定或无翻译的语言时使用I18N_DEFAULT_LANG
+	I18N_DIR          string
+	I18N_DEFAULT_LANG string
+
+	//退出时等待处理中的请求和关闭资源的时限，0不限制；GRACEFUL_RESTART开启后SIGUSR2平滑重启
+	SHUTDOWN_TIMEOUT_MS int
+	GRACEFUL_RESTART    bool
+
+	//退出时readyz先返回503，等待SHUTDOWN_READY_DELAY_MS后再停止接收请求；依赖检查的时限，默认1000
+	SHUTDOWN_READY_DELAY_MS int
+	HEALTH_CHECK_TIMEOUT_MS int
+
+	//span导出：为空不导出|otlp_file，TRACE_FILE相对log目录；上游未传traceparent时按TRACE_SAMPLE_RATIO(0-1)采样
+	TRACE_EXPORTER     string
+	TRACE_FILE         string
+	TRACE_SERVICE_NAME string
+	TRACE_SAMPLE_RATIO float64
+}
+
+//日志输出目标，可配置多个同时输出
+type Conf_Log_Sink struct {
+	TYPE  string //file|stdout|syslog|tcp|udp
+	LEVEL int32  //只输出不低于该严重程度的日志，例：3只输出WARNING及以上；<=0不过滤
+	PATH  string //file：相对log目录，默认LOG_FILE_NAME
+	ADDR  string //syslog：为空时使用本机syslog；tcp/udp：ip:port
+	TAG   string //syslog tag
 }
 
 const RETRY = 3
@@ -39,13 +94,30 @@ func init() {
 
 	ApiConf.LOG_FILE_DIR = appPath + "/log/"
 	ApiConf.LOG_FILE_NAME = ApiConf.LOG_FILE_DIR + ApiConf.LOG_FILE_NAME
+	if len(ApiConf.ACCESS_LOG_FILE_NAME) > 0 {
+		ApiConf.ACCESS_LOG_FILE_NAME = ApiConf.LOG_FILE_DIR + ApiConf.ACCESS_LOG_FILE_NAME
+	}
+	for i := range ApiConf.LOG_SINK {
+		if len(ApiConf.LOG_SINK[i].PATH) > 0 {
+			ApiConf.LOG_SINK[i].PATH = ApiConf.LOG_FILE_DIR + ApiConf.LOG_SINK[i].PATH
+		}
+	}
+	if len(ApiConf.NAME_SERVICE_FILE) > 0 {
+		ApiConf.NAME_SERVICE_FILE = appPath + "/conf/" + ApiConf.NAME_SERVICE_FILE
+	}
+	if len(ApiConf.TRACE_FILE) > 0 {
+		ApiConf.TRACE_FILE = ApiConf.LOG_FILE_DIR + ApiConf.TRACE_FILE
+	}
+	if len(ApiConf.I18N_DIR) > 0 {
+		ApiConf.I18N_DIR = appPath + "/conf/" + ApiConf.I18N_DIR
+	}
 	return
 }
 
 func V(item map[string]interface{}, keys ...string) (value interface{}) {
 	defer func() {
 		if r := recover(); r != nil {
-			panic(errors.New(ERROR_CONF_ERROR))
+			panic(apperr.New(ERROR_CONF_ERROR))
 		}
 	}()
 	if len(keys) == 0 {
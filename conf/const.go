@@ -7,9 +7,14 @@ const (
 	API_TIME          = "api_time"
 	RESULT_HEADER_MAP = "api_header_result"
 	RESULT_BODY_MAP   = "api_body_result"
+	RESULT_MESSAGE    = "api_result_message"
 	API_STATUS        = "api_status"
 	LOG_ID            = "log_id"
 	ERR_CODE          = "error_code"
 	ERR_MSG           = "error_msg"
+	ERR_DETAILS       = "error_details"
 	HTTP_CODE         = "http_code"
+	API_TIMEOUT       = "api_timeout"
+	API_ENCODER       = "api_encoder"
+	API_ROUTE         = "api_route"
 )
@@ -4,13 +4,22 @@ const (
 	TABLE_EXAMPLE = "table_example"
 )
 
+const (
+	DB_BALANCE_ROUNDROBIN = "roundrobin"
+	DB_BALANCE_WEIGHTED   = "weighted"
+)
+
 type DB_CLUSTER struct {
-	Db_cluster_tag string
-	Db_name        string
-	Username       string
-	Password       string
-	Server         []string
-	NameService    string
+	Db_cluster_tag  string
+	Db_name         string
+	Username        string
+	Password        string
+	Server          []string
+	NameService     string
+	Master          string   //写库，为空时取NameService或Server
+	Replicas        []string //读库，为空时读写都走master
+	Replica_weights []int    //与Replicas一一对应，Balance为weighted时生效
+	Balance         string   //roundrobin(默认)|weighted
 }
 
 type TABLE_VIEW struct {
@@ -28,6 +37,12 @@ type Conf_Db struct {
 	Max_conn_timeout   int
 	Db_cluster         []*DB_CLUSTER
 	Table_view         []*TABLE_VIEW
+
+	//读库健康检查间隔，检查失败摘除，恢复后重新加入
+	Health_check_interval_ms int
+
+	//DATETIME的时区，写入参数和读取结果都按此时区，例：Local、Asia/Shanghai；为空时同mysql driver，为UTC
+	Loc string
 }
 
 var tableDbTagMap map[string]string
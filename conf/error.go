@@ -2,6 +2,8 @@ package conf
 
 import (
 	"strconv"
+
+	"github.com/neil-peng/gomvc/apperr"
 )
 
 const (
@@ -22,47 +24,50 @@ const (
 	ERROR_GET_CACHE            = "10011"
 	ERROR_SET_CACHE            = "10012"
 	ERROR_FIELD_SCHEME_INVALID = "10013"
+	ERROR_TIMEOUT              = "10014"
 )
 
-var ArrErrorMessage = map[string]string{
-	NO_ERROR:                   "success",
-	ERROR_DB_QUERY_ERROR:       "db query error",
-	ERROR_DB_QUERY_DUPLICATE:   "db duplicate entry error",
-	ERROR_DB_CONNECT_ERROR:     "db connect error",
-	ERROR_DB_RESULT_SET_EMPTY:  "db result set is empty",
-	ERROR_NETWORK_ERROR:        "network error",
-	ERROR_SERVER_NOT_ACCESS:    "can not access server",
-	ERROR_PARAM_ERROR:          "param error",
-	ERROR_INNER_ERROR:          "innner error",
-	ERROR_NAMESERVICE_ERROR:    "get nameservice error",
-	ERROR_CONF_ERROR:           "parse conf error",
-	ERROR_CONN_CACHE:           "connect cache error",
-	ERROR_GET_CACHE:            "get cache error",
-	ERROR_SET_CACHE:            "set cache error",
-	ERROR_FIELD_SCHEME_INVALID: "db scheme error",
+//框架内置的错误码，业务错误码在各自包内通过apperr.Register注册
+var errorCodes = []apperr.Code{
+	{Errno: ERROR_DB_QUERY_ERROR, Status: 503, Msg: "db query error"},
+	{Errno: ERROR_DB_QUERY_DUPLICATE, Status: 503, Msg: "db duplicate entry error"},
+	{Errno: ERROR_DB_CONNECT_ERROR, Status: 503, Msg: "db connect error", Retryable: true},
+	{Errno: ERROR_DB_RESULT_SET_EMPTY, Status: 503, Msg: "db result set is empty"},
+	{Errno: ERROR_NETWORK_ERROR, Status: 503, Msg: "network error", Retryable: true},
+	{Errno: ERROR_SERVER_NOT_ACCESS, Status: 503, Msg: "can not access server", Retryable: true},
+	{Errno: ERROR_PARAM_ERROR, Status: 400, Msg: "param error"},
+	{Errno: ERROR_INNER_ERROR, Status: 503, Msg: "inner error"},
+	{Errno: ERROR_NAMESERVICE_ERROR, Status: 503, Msg: "get nameservice error", Retryable: true},
+	{Errno: ERROR_CONF_ERROR, Status: 503, Msg: "parse conf error"},
+	{Errno: ERROR_CONN_CACHE, Status: 503, Msg: "connect cache error", Retryable: true},
+	{Errno: ERROR_GET_CACHE, Status: 503, Msg: "get cache error"},
+	{Errno: ERROR_SET_CACHE, Status: 503, Msg: "set cache error"},
+	{Errno: ERROR_FIELD_SCHEME_INVALID, Status: 503, Msg: "db scheme error"},
+	{Errno: ERROR_TIMEOUT, Status: 504, Msg: "request timeout or canceled", Retryable: true},
 }
 
-var ArrHttpCode = map[string]int{
-	NO_ERROR:                   200,
-	ERROR_DB_QUERY_ERROR:       503,
-	ERROR_DB_QUERY_DUPLICATE:   503,
-	ERROR_DB_CONNECT_ERROR:     503,
-	ERROR_DB_RESULT_SET_EMPTY:  503,
-	ERROR_NETWORK_ERROR:        503,
-	ERROR_SERVER_NOT_ACCESS:    503,
-	ERROR_PARAM_ERROR:          400,
-	ERROR_INNER_ERROR:          503,
-	ERROR_NAMESERVICE_ERROR:    503,
-	ERROR_CONF_ERROR:           503,
-	ERROR_CONN_CACHE:           503,
-	ERROR_GET_CACHE:            503,
-	ERROR_SET_CACHE:            503,
-	ERROR_FIELD_SCHEME_INVALID: 503,
+//Deprecated: 框架内置错误码的信息，只读；使用apperr.Lookup
+var ArrErrorMessage = map[string]string{NO_ERROR: "success"}
+
+//Deprecated: 框架内置错误码的http状态码，只读；使用apperr.Lookup
+var ArrHttpCode = map[string]int{NO_ERROR: 200}
+
+func init() {
+	apperr.InnerErrno = ERROR_INNER_ERROR
+	for _, code := range errorCodes {
+		apperr.Register(code)
+		ArrErrorMessage[code.Errno] = code.Msg
+		ArrHttpCode[code.Errno] = code.Status
+	}
 }
 
+//Deprecated: 使用apperr.Lookup；未注册的错误码小于1000时为401，否则为200
 func GetHttpCode(errno string) int {
-	if _, ok := ArrHttpCode[errno]; ok {
-		return ArrHttpCode[errno]
+	if errno == NO_ERROR {
+		return 200
+	}
+	if code, ok := apperr.Lookup(errno); ok {
+		return code.Status
 	}
 	errorInt, _ := strconv.Atoi(errno)
 	if errorInt < 1000 {
@@ -71,9 +76,13 @@ func GetHttpCode(errno string) int {
 	return 200
 }
 
+//Deprecated: 使用apperr.Lookup
 func GetHttpMsg(errno string) string {
-	if _, ok := ArrErrorMessage[errno]; ok {
-		return ArrErrorMessage[errno]
+	if errno == NO_ERROR {
+		return "success"
+	}
+	if code, ok := apperr.Lookup(errno); ok {
+		return code.Msg
 	}
 	return "unknow errno:" + errno
 }
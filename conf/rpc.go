@@ -0,0 +1,8 @@
+package conf
+
+const (
+	RPC_CONNECT_TIMEOUTMS  = 100
+	RPC_TIMEOUTMS          = 1000
+	RPC_MAX_IDLE_CONNS     = 100
+	RPC_IDLE_CONN_TIMEOUTS = 90
+)
@@ -1,12 +1,13 @@
 package dao
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
+	"github.com/neil-peng/gomvc/lib/db"
 	"github.com/neil-peng/gomvc/utils"
 )
 
@@ -21,13 +22,10 @@ func (b *Base) GetTableView() string {
 
 //cache json转table类型
 func (b *Base) BuildImplicitField(m map[string]interface{}) (interface{}, error) {
-	var daoIns interface{}
-	switch b.TableView {
-	case conf.TABLE_EXAMPLE:
-		daoIns = &TableExample{}
-	default:
+	daoIns, err := db.NewModel(b.TableView)
+	if err != nil {
 		b.Critical("build invalid tableView from %s", b.TableView)
-		return nil, errors.New(conf.ERROR_FIELD_SCHEME_INVALID)
+		return nil, err
 	}
 
 	valueRf := reflect.ValueOf(daoIns).Elem()
@@ -80,7 +78,7 @@ func (b *Base) specInterface(srcValue interface{}, dstFieldValue *reflect.Value)
 			dstFieldValue.SetString(realSrcValue)
 		default:
 			b.Critical("[reflect field failed] [srctype:%s] [dsttype:%s]", srcType, dstType)
-			panic(errors.New(conf.ERROR_FIELD_SCHEME_INVALID))
+			panic(apperr.New(conf.ERROR_FIELD_SCHEME_INVALID))
 		}
 	case float32, float64:
 		switch dstType {
@@ -94,7 +92,7 @@ func (b *Base) specInterface(srcValue interface{}, dstFieldValue *reflect.Value)
 			dstFieldValue.SetString(fmt.Sprintf("%v", reflect.ValueOf(realSrcValue).Float()))
 		default:
 			b.Critical("[reflect field failed] [srctype:%s] [dsttype:%s]", srcType, dstType)
-			panic(errors.New(conf.ERROR_FIELD_SCHEME_INVALID))
+			panic(apperr.New(conf.ERROR_FIELD_SCHEME_INVALID))
 		}
 	case int, int8, int16, int32, int64:
 		switch dstType {
@@ -108,7 +106,7 @@ func (b *Base) specInterface(srcValue interface{}, dstFieldValue *reflect.Value)
 			dstFieldValue.SetString(fmt.Sprintf("%v", reflect.ValueOf(realSrcValue).Int()))
 		default:
 			b.Critical("[reflect field failed] [srctype:%s] [dsttype:%s]", srcType, dstType)
-			panic(errors.New(conf.ERROR_FIELD_SCHEME_INVALID))
+			panic(apperr.New(conf.ERROR_FIELD_SCHEME_INVALID))
 		}
 	case uint8, uint16, uint32, uint64:
 		switch dstType {
@@ -122,32 +120,24 @@ func (b *Base) specInterface(srcValue interface{}, dstFieldValue *reflect.Value)
 			dstFieldValue.SetString(fmt.Sprintf("%v", reflect.ValueOf(realSrcValue).Uint()))
 		default:
 			b.Critical("[reflect field failed] [srctype:%s] [dsttype:%s]", srcType, dstType)
-			panic(errors.New(conf.ERROR_FIELD_SCHEME_INVALID))
+			panic(apperr.New(conf.ERROR_FIELD_SCHEME_INVALID))
 		}
 	default:
 		b.Critical("[reflect field failed] [srctype:%s] [dsttype:%s]", srcType, dstType)
-		panic(errors.New(conf.ERROR_FIELD_SCHEME_INVALID))
+		panic(apperr.New(conf.ERROR_FIELD_SCHEME_INVALID))
 	}
 }
 
+//表描述通过db.RegisterTable注册到表视图
 func (b *Base) BuildField(m map[string]string) (interface{}, error) {
-	var daoIns interface{}
-	switch b.TableView {
-	case conf.TABLE_EXAMPLE:
-		daoIns = &TableExample{}
-	default:
+	daoIns, err := db.NewModel(b.TableView)
+	if err != nil {
 		b.Critical("build invalid tableView from %s", b.TableView)
-		return nil, errors.New(conf.ERROR_FIELD_SCHEME_INVALID)
+		return nil, err
 	}
-
-	valueRf := reflect.ValueOf(daoIns).Elem()
-	typeRf := valueRf.Type()
-	for i := 0; i < valueRf.NumField(); i++ {
-		fieldValue := valueRf.Field(i)
-		dbTag := typeRf.Field(i).Tag.Get("db")
-		if dbStrValue, ok := m[dbTag]; ok {
-			b.specInterface(dbStrValue, &fieldValue)
-		}
+	if err := db.BuildStruct(daoIns, m); err != nil {
+		b.Warn("build tableView %s fail, row:%v, err:%v", b.TableView, m, err)
+		return nil, err
 	}
 	return daoIns, nil
 }
@@ -17,6 +17,10 @@ type TableExample struct {
 	Detail string `db:"detail"`
 }
 
+func init() {
+	db.RegisterTable(conf.TABLE_EXAMPLE, &TableExample{})
+}
+
 //描述表视图，关联到表描述
 type TableExampleView struct {
 	Base
@@ -40,12 +44,12 @@ func (t *TableExampleView) Get(key string) (*TableExample, error) {
 	//函数计时
 	t.StatusStart()
 	defer t.StatusEnd()
-	tableExamples, err := db.New(t).Field("*").SetCond("id=\"%s\"", key).SelectToBuild()
+	tableExamples, err := db.SelectInto[TableExample](db.New(t).Where("id=?", key))
 	if err != nil {
 		return nil, err
 	}
 	if len(tableExamples) > 0 {
-		return tableExamples[0].(*TableExample), nil
+		return tableExamples[0], nil
 	}
 	return nil, nil
 }
@@ -1,15 +1,28 @@
 module github.com/neil-peng/gomvc
 
-go 1.14
+go 1.18
 
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/gin-gonic/gin v1.4.0
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/golang-collections/collections v0.0.0-20130729185459-604e922904d3
+	github.com/golang/protobuf v1.3.1
 	github.com/gomodule/redigo/redis v0.0.0-20200429221454-e14091dffc1b
+	github.com/ugorji/go v1.1.4
+	gotest.tools v2.2.0+incompatible
+)
+
+require (
+	github.com/gin-contrib/sse v0.0.0-20190301062529-5545eab6dad3 // indirect
 	github.com/google/go-cmp v0.3.1 // indirect
+	github.com/json-iterator/go v1.1.6 // indirect
+	github.com/mattn/go-isatty v0.0.7 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.1 // indirect
 	github.com/pkg/errors v0.8.1 // indirect
+	golang.org/x/sys v0.0.0-20190606165138-5da285871e9c // indirect
 	google.golang.org/appengine v1.6.2 // indirect
-	gotest.tools v2.2.0+incompatible
+	gopkg.in/go-playground/validator.v8 v8.18.2 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
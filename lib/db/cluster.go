@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/neil-peng/gomvc/utils"
+)
+
+const defaultHealthCheckIntervalMs = 1000
+
+//读库实例，healthy由健康检查维护
+type replica struct {
+	addr    string
+	ins     *sql.DB
+	weight  int
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	return atomic.SwapInt32(&r.healthy, v) != v
+}
+
+func openReplicas(cluster *conf.DB_CLUSTER) ([]*replica, error) {
+	var replicas []*replica
+	for i, addr := range cluster.Replicas {
+		ins, err := openMysql(cluster, addr)
+		if err != nil {
+			return nil, err
+		}
+		weight := 1
+		if i < len(cluster.Replica_weights) && cluster.Replica_weights[i] > 0 {
+			weight = cluster.Replica_weights[i]
+		}
+		replicas = append(replicas, &replica{
+			addr:    addr,
+			ins:     ins,
+			weight:  weight,
+			healthy: 1,
+		})
+	}
+	return replicas, nil
+}
+
+//选择读库：健康读库中轮询或按权重随机，全部不可用时回落到master
+func (d *Db) replica() *sql.DB {
+	var healthy []*replica
+	totalWeight := 0
+	for _, r := range d.replicas {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+			totalWeight += r.weight
+		}
+	}
+	if len(healthy) == 0 {
+		return d.mysqlIns
+	}
+
+	if d.cluster.Balance == conf.DB_BALANCE_WEIGHTED {
+		n := rand.Intn(totalWeight)
+		for _, r := range healthy {
+			if n < r.weight {
+				return r.ins
+			}
+			n -= r.weight
+		}
+	}
+	next := atomic.AddUint64(&d.next, 1)
+	return healthy[next%uint64(len(healthy))].ins
+}
+
+func (d *Db) healthCheck() {
+	intervalMs := conf.Db.Health_check_interval_ms
+	if intervalMs <= 0 {
+		intervalMs = defaultHealthCheckIntervalMs
+	}
+	interval := time.Duration(intervalMs) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		d.checkReplicas(interval)
+	}
+}
+
+//ping全部读库，失败的摘除，恢复的重新加入
+func (d *Db) checkReplicas(timeout time.Duration) {
+	for _, r := range d.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := r.ins.PingContext(ctx)
+		cancel()
+		if changed := r.setHealthy(err == nil); !changed {
+			continue
+		}
+		if err != nil {
+			utils.Warn("eject replica, cluster:%s, addr:%s, err:%v", d.cluster.Db_cluster_tag, r.addr, err)
+		} else {
+			utils.Notice("readmit replica, cluster:%s, addr:%s", d.cluster.Db_cluster_tag, r.addr)
+		}
+	}
+}
+
+//master注册为db:<tag>，每个读库注册为db:<tag>:<addr>
+func (d *Db) registerHealthChecks(tag string) {
+	utils.RegisterHealthCheck("db:"+tag, d.mysqlIns.PingContext)
+	for _, r := range d.replicas {
+		utils.RegisterHealthCheck("db:"+tag+":"+r.addr, r.ins.PingContext)
+	}
+}
+
+func (d *Db) unregisterHealthChecks(tag string) {
+	utils.UnregisterHealthCheck("db:" + tag)
+	for _, r := range d.replicas {
+		utils.UnregisterHealthCheck("db:" + tag + ":" + r.addr)
+	}
+}
+
+//停止健康检查，关闭master和读库；重复调用返回nil
+func (d *Db) close() error {
+	var err error
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		if d.unwatch != nil {
+			d.unwatch()
+		}
+		err = d.mysqlIns.Close()
+		for _, r := range d.replicas {
+			if rerr := r.ins.Close(); rerr != nil {
+				err = rerr
+			}
+		}
+	})
+	return err
+}
+
+//名字服务实例变更后关闭master的空闲连接，新连接经nameservice拨号到变更后的实例
+func (d *Db) onInstancesChanged(service string, instances []*utils.Instance) {
+	utils.Notice("db instances changed, cluster:%s, service:%s, instances:%d",
+		d.cluster.Db_cluster_tag, service, len(instances))
+	d.mysqlIns.SetMaxIdleConns(0)
+	d.mysqlIns.SetMaxIdleConns(conf.Db.Max_idle_conns)
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/neil-peng/gomvc/utils"
+	"gotest.tools/assert"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	newFakeCluster(t, "replica_routing", "master", "replica")
+	d := New(&fakeView{"replica_routing"})
+
+	_, err := d.Field("id").Where("id=?", 1).Select()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fakeDb.take("replica"), []string{"SELECT id FROM replica_routing WHERE id=?"})
+
+	//同一DbQuery先读后写，写仍走master
+	_, err = d.Delete()
+	assert.NilError(t, err)
+	_, err = d.RawExeccSql("UPDATE replica_routing SET name=? WHERE id=?", "a", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(fakeDb.take("replica")), 0)
+	assert.DeepEqual(t, fakeDb.take("master"), []string{
+		"DELETE FROM replica_routing WHERE id=?",
+		"UPDATE replica_routing SET name=? WHERE id=?",
+	})
+
+	_, err = d.RawQuerySql("SELECT 1")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fakeDb.take("master"), []string{"SELECT 1"})
+
+	_, err = d.ForceQueryMaster(true).Select()
+	assert.NilError(t, err)
+	assert.Equal(t, len(fakeDb.take("master")), 1)
+}
+
+func TestReplicaEject(t *testing.T) {
+	db := newFakeCluster(t, "replica_eject", "master", "r1", "r2")
+	fakeDb.setDown("r1", true)
+	db.checkReplicas(time.Second)
+	assert.Equal(t, db.replicas[0].isHealthy(), false)
+
+	for i := 0; i < 4; i++ {
+		_, err := New(&fakeView{"replica_eject"}).Select()
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, len(fakeDb.take("r2")), 4)
+
+	//全部读库不可用时回落到master
+	fakeDb.setDown("r2", true)
+	db.checkReplicas(time.Second)
+	_, err := New(&fakeView{"replica_eject"}).Select()
+	assert.NilError(t, err)
+	assert.Equal(t, len(fakeDb.take("master")), 1)
+
+	fakeDb.setDown("r1", false)
+	db.checkReplicas(time.Second)
+	assert.Equal(t, db.replicas[0].isHealthy(), true)
+}
+
+func TestCloseTwice(t *testing.T) {
+	db := newFakeCluster(t, "close_twice", "master", "replica")
+	assert.NilError(t, Close())
+	assert.Equal(t, len(ClusterTagToDbMap), 0)
+	assert.NilError(t, Close())
+	assert.NilError(t, db.close())
+}
+
+func TestReplicaHealthCheck(t *testing.T) {
+	db := newFakeCluster(t, "replica_health", "master", "r1", "r2")
+	db.registerHealthChecks("replica_health")
+	defer db.unregisterHealthChecks("replica_health")
+	fakeDb.setDown("r1", true)
+
+	report := utils.CheckHealth(context.Background())
+	assert.Equal(t, report.Status, utils.HEALTH_FAIL)
+	assert.Equal(t, report.Checks["db:replica_health"].Status, utils.HEALTH_OK)
+	assert.Equal(t, report.Checks["db:replica_health:r1"].Status, utils.HEALTH_FAIL)
+	assert.Equal(t, report.Checks["db:replica_health:r2"].Status, utils.HEALTH_OK)
+
+	db.unregisterHealthChecks("replica_health")
+	report = utils.CheckHealth(context.Background())
+	_, ok := report.Checks["db:replica_health:r1"]
+	assert.Equal(t, ok, false)
+}
+
+func TestCloseUnwatch(t *testing.T) {
+	db := newFakeCluster(t, "close_unwatch", "master")
+	registry := utils.NewFakeRegistry()
+	registry.Set("mvc-mysql", &utils.Instance{Ip: "127.0.0.1", Port: "3306"})
+	changed := 0
+	db.unwatch = registry.Watch("mvc-mysql", func(service string, instances []*utils.Instance) {
+		changed++
+	})
+	registry.Set("mvc-mysql", &utils.Instance{Ip: "127.0.0.2", Port: "3306"})
+	assert.Equal(t, changed, 1)
+
+	//关闭后不再回调
+	assert.NilError(t, db.close())
+	registry.Set("mvc-mysql", &utils.Instance{Ip: "127.0.0.3", Port: "3306"})
+	assert.Equal(t, changed, 1)
+}
+
+func TestCollectDbStats(t *testing.T) {
+	newFakeCluster(t, "collect_stats", "master", "replica")
+	var buf strings.Builder
+	assert.NilError(t, utils.WriteMetrics(&buf))
+	assert.Assert(t, strings.Contains(buf.String(), `gomvc_db_open_connections{cluster="",instance="replica"}`))
+
+	//采集与Close并发，关闭的集群不再输出
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			utils.WriteMetrics(ioutil.Discard)
+		}
+	}()
+	assert.NilError(t, Close())
+	<-done
+	buf.Reset()
+	assert.NilError(t, utils.WriteMetrics(&buf))
+	assert.Assert(t, !strings.Contains(buf.String(), `gomvc_db_open_connections{cluster="",instance="replica"}`))
+}
@@ -7,11 +7,14 @@ import (
 type Cond struct {
 	ands           []string
 	ors            []string
+	andArgs        []interface{}
+	orArgs         []interface{}
 	orderFieldAsc  []string
 	orderFieldDesc []string
 	limits         string
 	groupby        []string
 	having         string
+	havingArgs     []interface{}
 }
 
 func (c *Cond) and(format string, a ...interface{}) {
@@ -30,6 +33,17 @@ func (c *Cond) or(format string, a ...interface{}) {
 	}
 }
 
+//cond中以"?"占位，args随sql一起交给driver，不参与sql拼接
+func (c *Cond) andBind(cond string, args ...interface{}) {
+	c.ands = append(c.ands, cond)
+	c.andArgs = append(c.andArgs, args...)
+}
+
+func (c *Cond) orBind(cond string, args ...interface{}) {
+	c.ors = append(c.ors, cond)
+	c.orArgs = append(c.orArgs, args...)
+}
+
 func (c *Cond) limit(start int, offset int) {
 	c.limits = fmt.Sprintf(" LIMIT %d, %d", start, offset)
 }
@@ -64,6 +78,11 @@ func (c *Cond) Having(format string, a ...interface{}) {
 	}
 }
 
+func (c *Cond) havingBind(cond string, args ...interface{}) {
+	c.having = fmt.Sprintf(" HAVING %s", cond)
+	c.havingArgs = args
+}
+
 /*sql select format
 SELECT  [DISTINCT | ALL] {* | select_list}
 FROM {table_name [alias] | view_name}
@@ -146,3 +165,12 @@ func (c *Cond) format() string {
 	}
 	return partSql
 }
+
+//与format()中占位符的顺序一致：where(and, or) -> having
+func (c *Cond) args() []interface{} {
+	var args []interface{}
+	args = append(args, c.andArgs...)
+	args = append(args, c.orArgs...)
+	args = append(args, c.havingArgs...)
+	return args
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestBindCond(t *testing.T) {
+	c := &Cond{}
+	c.andBind("id=?", "1\" OR \"1\"=\"1")
+	c.and("status=%d", 2)
+	c.orBind("name=? AND age>?", "neil", 18)
+	c.havingBind("cnt>?", 3)
+	assert.Equal(t, c.format(), "id=? AND status=2 OR name=? AND age>? HAVING cnt>?")
+	assert.Equal(t, true, reflect.DeepEqual(c.args(),
+		[]interface{}{"1\" OR \"1\"=\"1", "neil", 18, 3}))
+}
+
+func TestBindField(t *testing.T) {
+	f := &Field{}
+	f.fieldValues("id", "k", "value", "v'", "ctime", "NOW()")
+	f.fieldRaw("ctime")
+	assert.Equal(t, f.formatValues(), "?, ?, NOW()")
+	assert.Equal(t, f.formatFieldValues(), "id=?, value=?, ctime=NOW()")
+	assert.Equal(t, true, reflect.DeepEqual(f.args(), []interface{}{"k", "v'"}))
+}
@@ -1,14 +1,17 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
 	"github.com/neil-peng/gomvc/utils"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -20,9 +23,30 @@ type DbViewer interface {
 	BuildFields([]map[string]string) ([]interface{}, error)
 }
 
+//*sql.DB与*sql.Tx的公共部分
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+//dbv内嵌*utils.Context时，sql随请求超时或客户端断开而取消
+type stdContexter interface {
+	StdContext() context.Context
+}
+
+//dbv内嵌*utils.Context时，每条sql是当前span的子span
+type spanStarter interface {
+	StartSpan(name string, kind utils.SpanKind) *utils.Span
+}
+
 type Db struct {
-	mysqlIns *sql.DB
+	mysqlIns *sql.DB //master
+	replicas []*replica
+	next     uint64
 	cluster  *conf.DB_CLUSTER
+	stop     chan struct{}
+	stopOnce sync.Once
+	unwatch  func() //取消名字服务的监听
 }
 
 type QueryFiled []string
@@ -32,37 +56,61 @@ type UpdateValues map[string]interface{}
 type InsertValues map[string]interface{}
 
 type DbQuery struct {
-	db          *Db
-	dbv         DbViewer
-	result      []map[string]string
-	err         error
-	field       *Field
-	cond        *Cond
-	sql         string
-	forceMaster bool
-}
-
+	db           *Db
+	exec         executor
+	dbv          DbViewer
+	result       []map[string]string
+	err          error
+	field        *Field
+	cond         *Cond
+	sql          string
+	args         []interface{}
+	lastInsertId int64
+	forceMaster  bool
+}
+
+//读写须持有clustersMu，Init、Close时整体替换
 var ClusterTagToDbMap map[string]*Db
 
+var clustersMu sync.RWMutex
+
+//conf.Db.Loc，driver格式化time.Time参数和BuildStruct解析DATETIME使用
+var dbLoc = time.UTC
+
 func Init(nameServer utils.NameService) {
+	loc, err := time.LoadLocation(conf.Db.Loc)
+	if err != nil {
+		panic(err)
+	}
+	dbLoc = loc
 	mysql.RegisterDial("nameservice", func(serviceName string) (net.Conn, error) {
 		ip, port, err := nameServer.GetServer(serviceName)
 		if err != nil {
 			utils.Critical("init db fail, name:%s, err:%v", serviceName, err)
 			return nil, err
 		}
-		addr := ip + ":" + port
+		addr := net.JoinHostPort(ip, port)
 		nd := net.Dialer{Timeout: time.Duration(conf.Db.Timeout_ms) * time.Millisecond}
-		return nd.Dial("tcp", addr)
+		start := time.Now()
+		c, err := nd.Dial("tcp", addr)
+		utils.ReportResult(nameServer, addr, err, time.Since(start))
+		if err != nil {
+			return nil, err
+		}
+		//连接上sql的网络错误也上报，摘除能连接但无法执行sql的实例
+		return utils.ReportConn(nameServer, addr, c), nil
 	})
 
-	ClusterTagToDbMap = make(map[string]*Db)
+	//重复Init时先关闭之前的连接池
+	Close()
+	clusters := make(map[string]*Db)
 	for _, cluster := range conf.Db.Db_cluster {
 		clusterTag := cluster.Db_cluster_tag
 		var mysqlIns *sql.DB
+		var replicas []*replica
 		var err error
 		for i := 0; i < conf.RETRY; i++ {
-			mysqlIns, err = openMysql(cluster)
+			mysqlIns, err = openMysql(cluster, cluster.Master)
 			if err == nil {
 				break
 			}
@@ -70,15 +118,57 @@ func Init(nameServer utils.NameService) {
 		if err != nil {
 			panic(err)
 		}
-		ClusterTagToDbMap[clusterTag] = &Db{
+		if replicas, err = openReplicas(cluster); err != nil {
+			panic(err)
+		}
+		db := &Db{
 			mysqlIns: mysqlIns,
+			replicas: replicas,
 			cluster:  cluster,
+			stop:     make(chan struct{}),
+		}
+		if len(replicas) > 0 {
+			go db.healthCheck()
 		}
+		if registry, ok := nameServer.(utils.Registry); ok && len(cluster.Master) == 0 && len(cluster.NameService) != 0 {
+			db.unwatch = registry.Watch(cluster.NameService, db.onInstancesChanged)
+		}
+		clusters[clusterTag] = db
+		db.registerHealthChecks(clusterTag)
 	}
+	clustersMu.Lock()
+	ClusterTagToDbMap = clusters
+	clustersMu.Unlock()
+	shutdownOnce.Do(func() {
+		utils.OnShutdown("db", utils.SHUTDOWN_ORDER_DB, func(ctx context.Context) error {
+			return Close()
+		})
+	})
 	return
 }
 
-func openMysql(cluster *conf.DB_CLUSTER) (*sql.DB, error) {
+var shutdownOnce sync.Once
+
+//关闭并移除全部db连接池，进程退出时调用；可重复调用
+func Close() error {
+	clustersMu.Lock()
+	clusters := ClusterTagToDbMap
+	ClusterTagToDbMap = map[string]*Db{}
+	clustersMu.Unlock()
+
+	var lastErr error
+	for tag, db := range clusters {
+		db.unregisterHealthChecks(tag)
+		if err := db.close(); err != nil {
+			utils.Warn("close db fail, cluster:%s, err:%v", tag, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+//addr为空时按NameService、Server的顺序取地址
+func openMysql(cluster *conf.DB_CLUSTER, addr string) (*sql.DB, error) {
 	dbConfig := &mysql.Config{
 		User:         cluster.Username,
 		Passwd:       cluster.Password,
@@ -86,9 +176,13 @@ func openMysql(cluster *conf.DB_CLUSTER) (*sql.DB, error) {
 		Timeout:      time.Duration(conf.Db.Timeout_ms) * time.Millisecond,
 		ReadTimeout:  time.Duration(conf.Db.Read_timeout_ms) * time.Millisecond,
 		WriteTimeout: time.Duration(conf.Db.Write_timeout_ms) * time.Millisecond,
+		Loc:          dbLoc,
 	}
 
-	if len(cluster.NameService) != 0 {
+	if len(addr) != 0 {
+		dbConfig.Net = "tcp"
+		dbConfig.Addr = addr
+	} else if len(cluster.NameService) != 0 {
 		dbConfig.Net = "nameservice"
 		dbConfig.Addr = cluster.NameService
 	} else {
@@ -104,14 +198,28 @@ func openMysql(cluster *conf.DB_CLUSTER) (*sql.DB, error) {
 		utils.Critical("open mysql fail, err:%v", err)
 		return nil, err
 	}
-	utils.Notice("open mysql success, conntimeout:%d, maxopen:%d, maxidle:%d",
-		conf.Db.Max_conn_timeout, conf.Db.Max_open_conns, conf.Db.Max_idle_conns)
+	utils.Notice("open mysql success, addr:%s, conntimeout:%d, maxopen:%d, maxidle:%d",
+		dbConfig.Addr, conf.Db.Max_conn_timeout, conf.Db.Max_open_conns, conf.Db.Max_idle_conns)
 	return mysqlIns, nil
 }
 
+//当前全部db集群的副本，遍历时不持有锁
+func snapshotClusters() map[string]*Db {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+	clusters := make(map[string]*Db, len(ClusterTagToDbMap))
+	for tag, db := range ClusterTagToDbMap {
+		clusters[tag] = db
+	}
+	return clusters
+}
+
 func New(dbv DbViewer) *DbQuery {
+	clustersMu.RLock()
+	db := ClusterTagToDbMap[conf.TableViewToDbCluster(dbv.GetTableView())]
+	clustersMu.RUnlock()
 	return &DbQuery{
-		db:    ClusterTagToDbMap[conf.TableViewToDbCluster(dbv.GetTableView())],
+		db:    db,
 		dbv:   dbv,
 		field: &Field{},
 		cond:  &Cond{},
@@ -124,6 +232,8 @@ func (d *DbQuery) Clear() {
 	d.cond = &Cond{}
 	d.err = nil
 	d.sql = ""
+	d.args = nil
+	d.lastInsertId = 0
 	d.forceMaster = false
 }
 
@@ -192,6 +302,32 @@ func (d *DbQuery) SetCond(format string, a ...interface{}) *DbQuery {
 	return d
 }
 
+//占位符方式的条件，例：Where("id=? AND status=?", id, status)
+func (d *DbQuery) Where(cond string, args ...interface{}) *DbQuery {
+	if len(cond) == 0 {
+		return d
+	}
+	d.cond.andBind(cond, args...)
+	return d
+}
+
+func (d *DbQuery) AndWhere(cond string, args ...interface{}) *DbQuery {
+	return d.Where(cond, args...)
+}
+
+func (d *DbQuery) OrWhere(cond string, args ...interface{}) *DbQuery {
+	if len(cond) == 0 {
+		return d
+	}
+	d.cond.orBind(cond, args...)
+	return d
+}
+
+func (d *DbQuery) HavingWhere(cond string, args ...interface{}) *DbQuery {
+	d.cond.havingBind(cond, args...)
+	return d
+}
+
 func (d *DbQuery) Limit(start int, offset int) *DbQuery {
 	if start >= 0 && offset >= 0 {
 		d.cond.limit(start, offset)
@@ -235,47 +371,129 @@ func (d *DbQuery) Insert() (affectedNum int64, err error) {
 		d.sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", d.dbv.GetTableView(),
 			d.field.formatFields(), d.field.formatValues())
 	}
+	d.args = d.field.args()
 	affectedNum, d.err = d.rawExeccSql()
 	if d.err != nil {
-		if me, ok := d.err.(*mysql.MySQLError); ok {
-			//Duplicate entry for key
-			if me.Number == 1062 {
-				return 0, errors.New(conf.ERROR_DB_QUERY_DUPLICATE)
-			}
+		return 0, d.insertError(d.err)
+	}
+	return affectedNum, nil
+}
+
+//插入单行并返回自增id
+func (d *DbQuery) InsertReturningId() (id int64, err error) {
+	if _, err = d.Insert(); err != nil {
+		return 0, err
+	}
+	return d.lastInsertId, nil
+}
+
+//一条语句插入多行，每行值的顺序与Fields一致，未指定Fields时按表字段顺序；
+//LastInsertId为第一行的自增id
+func (d *DbQuery) InsertMulti(rows ...[]interface{}) (affectedNum int64, err error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	var partSql string
+	var args []interface{}
+	for i, row := range rows {
+		if len(row) == 0 || len(row) != len(rows[0]) ||
+			(len(d.field.fieldItems) > 0 && len(row) != len(d.field.fieldItems)) {
+			utils.Warn("logid:%v, insert multi invalid row:%d, fields:%v, values:%v",
+				d.dbv.LogId(), i, d.field.fieldItems, row)
+			return 0, apperr.New(conf.ERROR_PARAM_ERROR)
+		}
+		rowField := &Field{}
+		rowField.values(row...)
+		if i > 0 {
+			partSql += ", "
 		}
-		return 0, errors.New(conf.ERROR_DB_QUERY_ERROR)
+		partSql += "(" + rowField.formatValues() + ")"
+		args = append(args, rowField.args()...)
+	}
+
+	if len(d.field.fieldItems) == 0 {
+		d.sql = fmt.Sprintf("INSERT INTO %s VALUES %s", d.dbv.GetTableView(), partSql)
+	} else {
+		d.sql = fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", d.dbv.GetTableView(),
+			d.field.formatFields(), partSql)
+	}
+	d.args = args
+	affectedNum, d.err = d.rawExeccSql()
+	if d.err != nil {
+		return 0, d.insertError(d.err)
 	}
 	return affectedNum, nil
 }
 
+func (d *DbQuery) insertError(err error) error {
+	if me, ok := err.(*mysql.MySQLError); ok {
+		//Duplicate entry for key
+		if me.Number == 1062 {
+			return apperr.New(conf.ERROR_DB_QUERY_DUPLICATE)
+		}
+	}
+	return d.errno(err, conf.ERROR_DB_QUERY_ERROR)
+}
+
+//请求超时或被取消时统一返回ERROR_TIMEOUT；driver的错误作为内部原因保留
+func (d *DbQuery) errno(err error, errno string) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || d.ctx().Err() != nil {
+		return apperr.Wrap(err, conf.ERROR_TIMEOUT)
+	}
+	return apperr.Wrap(err, errno)
+}
+
+func (d *DbQuery) ctx() context.Context {
+	if c, ok := d.dbv.(stdContexter); ok {
+		return c.StdContext()
+	}
+	return context.Background()
+}
+
+//sql的span，不含参数值
+func (d *DbQuery) startSpan() *utils.Span {
+	s, ok := d.dbv.(spanStarter)
+	if !ok {
+		return nil
+	}
+	cluster, typ := d.db.cluster.Db_cluster_tag, statementType(d.sql)
+	span := s.StartSpan(typ+" "+cluster, utils.SPAN_KIND_CLIENT)
+	span.SetAttr("db.system", "mysql")
+	span.SetAttr("db.name", cluster)
+	span.SetAttr("db.operation", typ)
+	span.SetAttr("db.statement", d.sql)
+	return span
+}
+
 func (d *DbQuery) Delete() (affectedNum int, err error) {
 	d.sql = fmt.Sprintf("DELETE FROM %s WHERE %s", d.dbv.GetTableView(), d.cond.format())
-	d.result, d.err = d.rawQuerySql()
-	if d.err != nil {
-		return 0, errors.New(conf.ERROR_DB_QUERY_ERROR)
+	d.args = d.cond.args()
+	rowsAffected, err := d.rawExeccSql()
+	if err != nil {
+		return 0, d.errno(err, conf.ERROR_DB_QUERY_ERROR)
 	}
-	//todo parse affectedNum
-	return 0, nil
+	return int(rowsAffected), nil
 }
 
 func (d *DbQuery) Update() (affectedNum int, err error) {
 	d.sql = fmt.Sprintf("UPDATE %s SET %s WHERE %s", d.dbv.GetTableView(),
 		d.field.formatFieldValues(), d.cond.format())
+	d.args = append(d.field.args(), d.cond.args()...)
 
-	d.result, d.err = d.rawQuerySql()
-	if d.err != nil {
-		return 0, errors.New(conf.ERROR_DB_QUERY_ERROR)
+	rowsAffected, err := d.rawExeccSql()
+	if err != nil {
+		return 0, d.errno(err, conf.ERROR_DB_QUERY_ERROR)
 	}
-	//todo parse affectedNum
-	return 0, nil
+	return int(rowsAffected), nil
 }
 
 func (d *DbQuery) Select() ([]map[string]string, error) {
 	d.sql = fmt.Sprintf("SELECT %s FROM %s WHERE %s", d.field.formatFields(),
 		d.dbv.GetTableView(), d.cond.format())
-	d.result, d.err = d.rawQuerySql()
+	d.args = d.cond.args()
+	d.result, d.err = d.rawQuerySql(true)
 	if d.err != nil {
-		return nil, errors.New(conf.ERROR_DB_QUERY_ERROR)
+		return nil, d.errno(d.err, conf.ERROR_DB_QUERY_ERROR)
 	}
 	return d.result, nil
 }
@@ -283,16 +501,21 @@ func (d *DbQuery) Select() ([]map[string]string, error) {
 func (d *DbQuery) SelectToBuild() ([]interface{}, error) {
 	d.sql = fmt.Sprintf("SELECT %s FROM %s WHERE %s", d.field.formatFields(),
 		d.dbv.GetTableView(), d.cond.format())
-	d.result, d.err = d.rawQuerySql()
+	d.args = d.cond.args()
+	d.result, d.err = d.rawQuerySql(true)
+	if d.err != nil {
+		return nil, d.errno(d.err, conf.ERROR_DB_QUERY_ERROR)
+	}
 	return d.BuildFields()
 }
 
 func (d *DbQuery) SelectToCount() (int, error) {
 	d.sql = fmt.Sprintf("SELECT %s FROM %s WHERE %s", d.field.formatFields(),
 		d.dbv.GetTableView(), d.cond.format())
-	d.result, d.err = d.rawQuerySql()
+	d.args = d.cond.args()
+	d.result, d.err = d.rawQuerySql(true)
 	if d.err != nil {
-		return 0, d.err
+		return 0, d.errno(d.err, conf.ERROR_DB_QUERY_ERROR)
 	}
 	return len(d.result), nil
 }
@@ -308,16 +531,43 @@ func (d *DbQuery) Sql() string {
 	return d.sql
 }
 
-func (d *DbQuery) RawQuerySql(sqlStr string) ([]map[string]string, error) {
+//最近一次Insert/InsertMulti/RawExeccSql的自增id
+func (d *DbQuery) LastInsertId() int64 {
+	return d.lastInsertId
+}
+
+//sql中"?"占位符对应的参数
+func (d *DbQuery) Args() []interface{} {
+	return d.args
+}
+
+func (d *DbQuery) RawQuerySql(sqlStr string, args ...interface{}) ([]map[string]string, error) {
 	d.sql = sqlStr
-	return d.rawQuerySql()
+	d.args = args
+	return d.rawQuerySql(false)
 }
 
-func (d *DbQuery) RawExeccSql(sqlStr string) (int64, error) {
+func (d *DbQuery) RawExeccSql(sqlStr string, args ...interface{}) (int64, error) {
 	d.sql = sqlStr
+	d.args = args
 	return d.rawExeccSql()
 }
 
+//readOnly由每次调用传入，Select*为true；写操作、RawQuerySql、事务和ForceQueryMaster(true)走master
+func (d *DbQuery) instance(readOnly bool) *sql.DB {
+	if readOnly && !d.forceMaster && d.exec == nil {
+		return d.db.replica()
+	}
+	return d.db.mysqlIns
+}
+
+func (d *DbQuery) executor(mysqlIns *sql.DB) executor {
+	if d.exec != nil {
+		return d.exec
+	}
+	return mysqlIns
+}
+
 func (d *DbQuery) addHint() string {
 	commentParam := make(map[string]interface{})
 	commentParam["comment"] = 1
@@ -332,45 +582,55 @@ func (d *DbQuery) addHint() string {
 func (d *DbQuery) rawExeccSql() (int64, error) {
 	cost := time.Now()
 	sqlFormat := d.addHint() + d.Sql()
-	mysqlIns := d.db.mysqlIns
+	mysqlIns := d.instance(false)
 	logId := d.dbv.LogId()
+	span := d.startSpan()
 	var affectedNum int64
 	defer func() {
-		utils.Info("logid:%v, status:%+v, sql:%s, affectedNum:%d, err:%v, cost:%dus",
-			logId, mysqlIns.Stats(), sqlFormat, affectedNum, d.err, time.Since(cost)/time.Microsecond)
+		d.observe(cost, d.err)
+		span.End(d.err)
+		utils.Info("logid:%v, status:%+v, sql:%s, args:%v, affectedNum:%d, lastInsertId:%d, err:%v, cost:%dus",
+			logId, mysqlIns.Stats(), sqlFormat, d.args, affectedNum, d.lastInsertId, d.err, time.Since(cost)/time.Microsecond)
 	}()
 
 	var result sql.Result
-	result, d.err = mysqlIns.Exec(sqlFormat)
+	result, d.err = d.executor(mysqlIns).ExecContext(d.ctx(), sqlFormat, d.args...)
 	if d.err != nil {
-		utils.Warn("logid:%v, exec fail, sql:%s, err:%v", logId, sqlFormat, d.err)
+		utils.Warn("logid:%v, exec fail, sql:%s, args:%v, err:%v", logId, sqlFormat, d.args, d.err)
 		return 0, d.err
 	}
 	if affectedNum, d.err = result.RowsAffected(); d.err != nil {
 		utils.Warn("logid:%v, exec error] [sql:%s] [err:%v]", logId, sqlFormat, d.err)
 		return 0, d.err
 	}
+	if d.lastInsertId, d.err = result.LastInsertId(); d.err != nil {
+		utils.Warn("logid:%v, exec error] [sql:%s] [err:%v]", logId, sqlFormat, d.err)
+		return 0, d.err
+	}
 
 	return affectedNum, d.err
 }
 
 //查询失败error!=nil; 查询为空map=nil，error=nil
-func (d *DbQuery) rawQuerySql() ([]map[string]string, error) {
+func (d *DbQuery) rawQuerySql(readOnly bool) ([]map[string]string, error) {
 	cost := time.Now()
 	sqlFormat := d.addHint() + d.Sql()
-	mysqlIns := d.db.mysqlIns
+	mysqlIns := d.instance(readOnly)
 	logId := d.dbv.LogId()
+	span := d.startSpan()
 	defer func() {
-		utils.Info("logid:%v, status:%+v, sql:%s, len_res:%d, cost:%dus, err:%v]",
-			logId, mysqlIns.Stats(), sqlFormat, len(d.result), time.Since(cost)/time.Microsecond, d.err)
+		d.observe(cost, d.err)
+		span.End(d.err)
+		utils.Info("logid:%v, status:%+v, sql:%s, args:%v, len_res:%d, cost:%dus, err:%v]",
+			logId, mysqlIns.Stats(), sqlFormat, d.args, len(d.result), time.Since(cost)/time.Microsecond, d.err)
 	}()
 
 	var rows *sql.Rows
 	var cols []string
 
-	rows, d.err = mysqlIns.Query(sqlFormat)
+	rows, d.err = d.executor(mysqlIns).QueryContext(d.ctx(), sqlFormat, d.args...)
 	if d.err != nil {
-		utils.Warn("[logid:%v] [query error] [sql:%s] [err:%v]", logId, sqlFormat, d.err)
+		utils.Warn("[logid:%v] [query error] [sql:%s] [args:%v] [err:%v]", logId, sqlFormat, d.args, d.err)
 		return nil, d.err
 	}
 	defer rows.Close()
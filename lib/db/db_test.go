@@ -0,0 +1,26 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neil-peng/gomvc/apperr"
+	"github.com/neil-peng/gomvc/conf"
+	"gotest.tools/assert"
+)
+
+func TestQueryErrno(t *testing.T) {
+	newFakeCluster(t, "query_errno", "master")
+	fakeDb.setDown("master", true)
+
+	//返回错误码，driver的错误作为内部原因保留
+	_, err := New(&fakeView{"query_errno"}).Field("id").Select()
+	assert.Assert(t, apperr.Is(err, conf.ERROR_DB_QUERY_ERROR), err)
+	assert.Assert(t, strings.Contains(err.Error(), "master down"), err)
+
+	_, err = New(&fakeView{"query_errno"}).Field("id").SelectToBuild()
+	assert.Assert(t, apperr.Is(err, conf.ERROR_DB_QUERY_ERROR), err)
+	_, err = New(&fakeView{"query_errno"}).Field("id").SelectToCount()
+	assert.Assert(t, apperr.Is(err, conf.ERROR_DB_QUERY_ERROR), err)
+	assert.Assert(t, strings.Contains(err.Error(), "master down"), err)
+}
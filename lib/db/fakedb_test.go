@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//测试用driver，dsn为实例名；记录各实例执行的语句，down中的实例ping和执行均失败
+type fakeDriver struct {
+	stmts map[string][]string
+	down  map[string]bool
+	mu    sync.Mutex
+}
+
+var fakeDb = &fakeDriver{stmts: map[string][]string{}, down: map[string]bool{}}
+
+func init() {
+	sql.Register("fakedb", fakeDb)
+}
+
+func (f *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	return &fakeConn{dsn: dsn}, nil
+}
+
+func (f *fakeDriver) record(dsn, stmt string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down[dsn] {
+		return errors.New("fakedb: " + dsn + " down")
+	}
+	//去掉addHint的注释
+	if i := strings.Index(stmt, "*/"); strings.HasPrefix(stmt, "/*") && i > 0 {
+		stmt = stmt[i+2:]
+	}
+	f.stmts[dsn] = append(f.stmts[dsn], stmt)
+	return nil
+}
+
+func (f *fakeDriver) setDown(dsn string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down[dsn] = down
+}
+
+//返回并清空实例执行过的语句
+func (f *fakeDriver) take(dsn string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stmts := f.stmts[dsn]
+	delete(f.stmts, dsn)
+	return stmts
+}
+
+type fakeConn struct {
+	dsn string
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fakedb: prepare not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if err := fakeDb.record(c.dsn, "BEGIN"); err != nil {
+		return nil, err
+	}
+	return &fakeTx{dsn: c.dsn}, nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	fakeDb.mu.Lock()
+	defer fakeDb.mu.Unlock()
+	if fakeDb.down[c.dsn] {
+		return errors.New("fakedb: " + c.dsn + " down")
+	}
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := fakeDb.record(c.dsn, query); err != nil {
+		return nil, err
+	}
+	return fakeResult{}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := fakeDb.record(c.dsn, query); err != nil {
+		return nil, err
+	}
+	return &fakeRows{}, nil
+}
+
+//影响1行，自增id为1
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (fakeResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+type fakeTx struct {
+	dsn string
+}
+
+func (t *fakeTx) Commit() error {
+	return fakeDb.record(t.dsn, "COMMIT")
+}
+
+func (t *fakeTx) Rollback() error {
+	return fakeDb.record(t.dsn, "ROLLBACK")
+}
+
+//一行，只有id列
+type fakeRows struct {
+	done bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = []byte("1")
+	return nil
+}
+
+type fakeView struct {
+	table string
+}
+
+func (v *fakeView) GetTableView() string {
+	return v.table
+}
+
+func (v *fakeView) LogId() string {
+	return "1"
+}
+
+func (v *fakeView) BuildFields(rows []map[string]string) ([]interface{}, error) {
+	return nil, nil
+}
+
+//以fakedb实例组成集群作为未配置的表视图table的db，测试结束时还原
+func newFakeCluster(t *testing.T, table, master string, replicas ...string) *Db {
+	open := func(dsn string) *sql.DB {
+		ins, err := sql.Open("fakedb", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fakeDb.take(dsn)
+		return ins
+	}
+	db := &Db{
+		mysqlIns: open(master),
+		cluster:  &conf.DB_CLUSTER{Db_cluster_tag: table},
+		stop:     make(chan struct{}),
+	}
+	for _, addr := range replicas {
+		db.replicas = append(db.replicas, &replica{addr: addr, ins: open(addr), weight: 1, healthy: 1})
+	}
+
+	//未配置的表视图对应的集群tag为空
+	clustersMu.Lock()
+	old := ClusterTagToDbMap
+	ClusterTagToDbMap = map[string]*Db{conf.TableViewToDbCluster(table): db}
+	clustersMu.Unlock()
+	t.Cleanup(func() {
+		clustersMu.Lock()
+		ClusterTagToDbMap = old
+		clustersMu.Unlock()
+		for _, addr := range append([]string{master}, replicas...) {
+			fakeDb.setDown(addr, false)
+			fakeDb.take(addr)
+		}
+	})
+	return db
+}
@@ -70,16 +70,18 @@ func (f *Field) formatFields() string {
 	return partSql
 }
 
+//值统一以"?"占位，由args()按相同顺序给出；FieldRawValue指定的字段原样拼入sql
 func (f *Field) formatValues() string {
 	var partSql string
-	for _, valueItem := range f.valueItems {
-		if valueItemStr, ok := valueItem.(string); ok {
-			valueItem = "'" + utils.Addslashes(valueItemStr) + "'"
+	for index, valueItem := range f.valueItems {
+		item := "?"
+		if f.isRaw(index) {
+			item = fmt.Sprintf("%v", valueItem)
 		}
 		if len(partSql) == 0 {
-			partSql = fmt.Sprintf("%v", valueItem)
+			partSql = item
 		} else {
-			partSql += fmt.Sprintf(", %v", valueItem)
+			partSql += ", " + item
 		}
 	}
 	return partSql
@@ -92,21 +94,37 @@ func (f *Field) formatFieldValues() string {
 	}
 
 	for index, fieldItem := range f.fieldItems {
-		valueItem := f.valueItems[index]
-		if !utils.InStringArray(f.fieldRawItems, fieldItem) {
-			if valueItemStr, ok := valueItem.(string); ok {
-				valueItem = "'" + utils.Addslashes(valueItemStr) + "'"
-			}
+		item := "?"
+		if f.isRaw(index) {
+			item = fmt.Sprintf("%v", f.valueItems[index])
 		}
 		if len(partSql) == 0 {
-			partSql = fmt.Sprintf("%s=%v", fieldItem, valueItem)
+			partSql = fmt.Sprintf("%s=%s", fieldItem, item)
 		} else {
-			partSql += fmt.Sprintf(", %s=%v", fieldItem, valueItem)
+			partSql += fmt.Sprintf(", %s=%s", fieldItem, item)
 		}
 	}
 	return partSql
 }
 
+func (f *Field) isRaw(index int) bool {
+	if index >= len(f.fieldItems) {
+		return false
+	}
+	return utils.InStringArray(f.fieldRawItems, f.fieldItems[index])
+}
+
+//formatValues/formatFieldValues中占位符对应的参数
+func (f *Field) args() []interface{} {
+	var args []interface{}
+	for index, valueItem := range f.valueItems {
+		if !f.isRaw(index) {
+			args = append(args, valueItem)
+		}
+	}
+	return args
+}
+
 func (f *Field) FormatIntoMap() map[string]string {
 	if len(f.fieldItems) != len(f.valueItems) {
 		panic("fields num not equal values")
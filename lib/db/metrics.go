@@ -0,0 +1,64 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/neil-peng/gomvc/utils"
+)
+
+var (
+	queryDuration = utils.NewHistogram("gomvc_db_query_duration_seconds",
+		"Db query latency by cluster and statement type.", nil, "cluster", "type")
+	queryErrors = utils.NewCounter("gomvc_db_query_errors_total",
+		"Failed db queries by cluster and statement type.", "cluster", "type")
+
+	dbOpenConns   = utils.NewGauge("gomvc_db_open_connections", "Open connections, sql.DBStats.OpenConnections.", "cluster", "instance")
+	dbInUseConns  = utils.NewGauge("gomvc_db_in_use_connections", "Connections in use, sql.DBStats.InUse.", "cluster", "instance")
+	dbIdleConns   = utils.NewGauge("gomvc_db_idle_connections", "Idle connections, sql.DBStats.Idle.", "cluster", "instance")
+	dbWaitCount   = utils.NewCounter("gomvc_db_wait_count_total", "Total connections waited for, sql.DBStats.WaitCount.", "cluster", "instance")
+	dbWaitSeconds = utils.NewCounter("gomvc_db_wait_duration_seconds_total", "Total time waited for connections, sql.DBStats.WaitDuration.", "cluster", "instance")
+)
+
+func init() {
+	utils.OnMetricsCollect(collectDbStats)
+}
+
+//只输出当前存在的集群；master的instance为master，读库为其地址
+func collectDbStats() {
+	dbOpenConns.Reset()
+	dbInUseConns.Reset()
+	dbIdleConns.Reset()
+	for tag, db := range snapshotClusters() {
+		setDbStats(tag, "master", db.mysqlIns.Stats())
+		for _, r := range db.replicas {
+			setDbStats(tag, r.addr, r.ins.Stats())
+		}
+	}
+}
+
+func setDbStats(cluster, instance string, stats sql.DBStats) {
+	dbOpenConns.Set(float64(stats.OpenConnections), cluster, instance)
+	dbInUseConns.Set(float64(stats.InUse), cluster, instance)
+	dbIdleConns.Set(float64(stats.Idle), cluster, instance)
+	dbWaitCount.Set(float64(stats.WaitCount), cluster, instance)
+	dbWaitSeconds.Set(stats.WaitDuration.Seconds(), cluster, instance)
+}
+
+func (d *DbQuery) observe(start time.Time, err error) {
+	cluster, typ := d.db.cluster.Db_cluster_tag, statementType(d.sql)
+	queryDuration.ObserveDuration(time.Since(start), cluster, typ)
+	if err != nil {
+		queryErrors.Inc(cluster, typ)
+	}
+}
+
+//sql的第一个关键字，例：SELECT、INSERT
+func statementType(sqlStr string) string {
+	fields := strings.Fields(sqlStr)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
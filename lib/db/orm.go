@@ -0,0 +1,282 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neil-peng/gomvc/apperr"
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/neil-peng/gomvc/utils"
+)
+
+//表描述字段的tag：`db:"列名[,json][,omitempty]"`
+//json: 列内容为json，与字段之间json编解码
+//omitempty: InsertStruct时零值不插入，用于自增id、默认值等列
+const (
+	tagOptJson      = "json"
+	tagOptOmitempty = "omitempty"
+)
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02",
+	time.RFC3339Nano,
+}
+
+type columnMeta struct {
+	name      string
+	index     int
+	json      bool
+	omitempty bool
+}
+
+var (
+	tableRegistry   = map[string]reflect.Type{}
+	tableRegistryMu sync.RWMutex
+	columnCache     sync.Map //reflect.Type -> []columnMeta
+)
+
+//注册表视图对应的表描述，model为带db tag的结构体或其指针，通常在dao的init中调用
+func RegisterTable(tableView string, model interface{}) {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic("register table " + tableView + " with non struct model")
+	}
+	tableRegistryMu.Lock()
+	defer tableRegistryMu.Unlock()
+	tableRegistry[tableView] = t
+}
+
+//按注册的表描述新建实例，返回结构体指针
+func NewModel(tableView string) (interface{}, error) {
+	tableRegistryMu.RLock()
+	t, ok := tableRegistry[tableView]
+	tableRegistryMu.RUnlock()
+	if !ok {
+		utils.Critical("table view %s not registered", tableView)
+		return nil, apperr.New(conf.ERROR_FIELD_SCHEME_INVALID)
+	}
+	return reflect.New(t).Interface(), nil
+}
+
+//将一行查询结果填入结构体指针dst，不存在的列视为NULL
+func BuildStruct(dst interface{}, row map[string]string) error {
+	dstValue := reflect.ValueOf(dst)
+	if dstValue.Kind() != reflect.Ptr || dstValue.Elem().Kind() != reflect.Struct {
+		return apperr.New(conf.ERROR_FIELD_SCHEME_INVALID)
+	}
+	dstValue = dstValue.Elem()
+	for _, col := range columnsOf(dstValue.Type()) {
+		dbValue, ok := row[col.name]
+		if err := setColumn(dstValue.Field(col.index), col, dbValue, !ok); err != nil {
+			utils.Warn("build column %s fail, value:%s, err:%v", col.name, dbValue, err)
+			return apperr.New(conf.ERROR_FIELD_SCHEME_INVALID)
+		}
+	}
+	return nil
+}
+
+//查询并映射为T，未指定Field时查询T的全部列
+//例：db.SelectInto[TableExample](db.New(t).Where("id=?", key))
+func SelectInto[T any](d *DbQuery) ([]*T, error) {
+	if len(d.field.fieldItems) == 0 {
+		for _, col := range columnsOf(reflect.TypeOf((*T)(nil)).Elem()) {
+			d.field.field(col.name)
+		}
+	}
+	rows, err := d.Select()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*T, 0, len(rows))
+	for _, row := range rows {
+		item := new(T)
+		if err := BuildStruct(item, row); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+//按结构体的db tag插入一行
+func InsertStruct(d *DbQuery, src interface{}) (affectedNum int64, err error) {
+	srcValue := reflect.Indirect(reflect.ValueOf(src))
+	for _, col := range columnsOf(srcValue.Type()) {
+		fieldValue := srcValue.Field(col.index)
+		if col.omitempty && fieldValue.IsZero() {
+			continue
+		}
+		v, err := columnValue(fieldValue, col)
+		if err != nil {
+			return 0, err
+		}
+		d.FieldValue(col.name, v)
+	}
+	return d.Insert()
+}
+
+//按结构体的db tag更新，fields为空时更新全部列，条件由Where/SetCond指定
+func UpdateStruct(d *DbQuery, src interface{}, fields ...string) (affectedNum int, err error) {
+	srcValue := reflect.Indirect(reflect.ValueOf(src))
+	for _, col := range columnsOf(srcValue.Type()) {
+		if len(fields) > 0 && !utils.InStringArray(fields, col.name) {
+			continue
+		}
+		v, err := columnValue(srcValue.Field(col.index), col)
+		if err != nil {
+			return 0, err
+		}
+		d.FieldValue(col.name, v)
+	}
+	return d.Update()
+}
+
+func columnsOf(t reflect.Type) []columnMeta {
+	if cols, ok := columnCache.Load(t); ok {
+		return cols.([]columnMeta)
+	}
+	var cols []columnMeta
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("db")
+		if len(tag) == 0 || tag == "-" {
+			continue
+		}
+		//未导出的字段无法通过反射赋值
+		if len(sf.PkgPath) > 0 {
+			utils.Warn("unexported field %s.%s with db tag ignored", t.Name(), sf.Name)
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		col := columnMeta{name: opts[0], index: i}
+		for _, opt := range opts[1:] {
+			switch strings.TrimSpace(opt) {
+			case tagOptJson:
+				col.json = true
+			case tagOptOmitempty:
+				col.omitempty = true
+			}
+		}
+		cols = append(cols, col)
+	}
+	columnCache.Store(t, cols)
+	return cols
+}
+
+func setColumn(fv reflect.Value, col columnMeta, dbValue string, isNull bool) error {
+	if scanner, ok := fv.Addr().Interface().(sql.Scanner); ok {
+		if isNull {
+			return scanner.Scan(nil)
+		}
+		//同driver的原始值传入[]byte；sql.NullTime等不接受[]byte的按DATETIME解析后传入
+		err := scanner.Scan([]byte(dbValue))
+		if err != nil {
+			if t, terr := parseTime(dbValue); terr == nil {
+				return scanner.Scan(t)
+			}
+		}
+		return err
+	}
+	if fv.Kind() == reflect.Ptr {
+		if isNull {
+			fv.Set(reflect.Zero(fv.Type()))
+			return nil
+		}
+		fv.Set(reflect.New(fv.Type().Elem()))
+		fv = fv.Elem()
+	}
+	if isNull {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+	if col.json {
+		return json.Unmarshal([]byte(dbValue), fv.Addr().Interface())
+	}
+
+	if _, ok := fv.Interface().(time.Time); ok {
+		t, err := parseTime(dbValue)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(dbValue)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(dbValue)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(dbValue, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(dbValue, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(dbValue, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.Uint8 {
+			return errors.New("unsupported slice type:" + fv.Type().String())
+		}
+		fv.SetBytes([]byte(dbValue))
+	default:
+		return errors.New("unsupported type:" + fv.Type().String())
+	}
+	return nil
+}
+
+//按dbLoc解析，与driver写入time.Time参数的时区一致
+func parseTime(dbValue string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, dbValue, dbLoc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time:" + dbValue)
+}
+
+//结构体字段转为sql参数，nil指针为NULL
+func columnValue(fv reflect.Value, col columnMeta) (interface{}, error) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil, nil
+		}
+		if !col.json {
+			fv = fv.Elem()
+		}
+	}
+	if col.json {
+		b, err := json.Marshal(fv.Interface())
+		if err != nil {
+			utils.Warn("marshal json column %s fail, err:%v", col.name, err)
+			return nil, apperr.New(conf.ERROR_FIELD_SCHEME_INVALID)
+		}
+		return string(b), nil
+	}
+	return fv.Interface(), nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+type ormExample struct {
+	Id      int64             `db:"id,omitempty"`
+	Name    string            `db:"name"`
+	Enabled bool              `db:"enabled"`
+	Score   *float64          `db:"score"`
+	Remark  sql.NullString    `db:"remark"`
+	Ctime   time.Time         `db:"ctime"`
+	Extra   map[string]string `db:"extra,json"`
+	Ignored string
+}
+
+func TestBuildStruct(t *testing.T) {
+	e := &ormExample{}
+	err := BuildStruct(e, map[string]string{
+		"id":      "7",
+		"name":    "neil",
+		"enabled": "1",
+		"ctime":   "2020-05-01 12:30:00",
+		"extra":   `{"k":"v"}`,
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, e.Id, int64(7))
+	assert.Equal(t, e.Name, "neil")
+	assert.Equal(t, e.Enabled, true)
+	assert.Equal(t, true, e.Score == nil)
+	assert.Equal(t, e.Remark.Valid, false)
+	assert.Equal(t, e.Ctime, time.Date(2020, 5, 1, 12, 30, 0, 0, time.UTC))
+	assert.Equal(t, e.Extra["k"], "v")
+
+	err = BuildStruct(e, map[string]string{"score": "1.5", "remark": "r"})
+	assert.NilError(t, err)
+	assert.Equal(t, *e.Score, 1.5)
+	assert.Equal(t, e.Remark.String, "r")
+
+	err = BuildStruct(e, map[string]string{"id": "x"})
+	assert.Equal(t, true, err != nil)
+}
+
+func TestColumnValue(t *testing.T) {
+	e := &ormExample{Extra: map[string]string{"k": "v"}}
+	var values []interface{}
+	for _, col := range columnsOf(reflect.TypeOf(*e)) {
+		v, err := columnValue(reflect.ValueOf(e).Elem().Field(col.index), col)
+		assert.NilError(t, err)
+		values = append(values, v)
+	}
+	assert.Equal(t, len(values), 7)
+	assert.Equal(t, values[3], nil)
+	assert.Equal(t, values[6], `{"k":"v"}`)
+}
+
+type ormUnexported struct {
+	Id   int64  `db:"id"`
+	name string `db:"name"`
+}
+
+func TestOrmSkipUnexported(t *testing.T) {
+	RegisterTable("orm_unexported", &ormUnexported{})
+	model, err := NewModel("orm_unexported")
+	assert.NilError(t, err)
+	e := model.(*ormUnexported)
+	assert.NilError(t, BuildStruct(e, map[string]string{"id": "7", "name": "neil"}))
+	assert.Equal(t, e.Id, int64(7))
+	assert.Equal(t, e.name, "")
+
+	newFakeCluster(t, "orm_unexported", "master")
+	_, err = InsertStruct(New(&fakeView{"orm_unexported"}), &ormUnexported{Id: 1, name: "neil"})
+	assert.NilError(t, err)
+	stmts := fakeDb.take("master")
+	assert.Equal(t, len(stmts), 1)
+	assert.Assert(t, !strings.Contains(stmts[0], "name"), stmts[0])
+}
+
+type ormTime struct {
+	Ctime time.Time     `db:"ctime"`
+	Mtime sql.NullTime  `db:"mtime"`
+	Count sql.NullInt64 `db:"count"`
+}
+
+func TestBuildStructLoc(t *testing.T) {
+	old := dbLoc
+	dbLoc = time.FixedZone("UTC+8", 8*3600)
+	defer func() { dbLoc = old }()
+
+	//driver按dbLoc格式化写入的time.Time，读取后为同一时刻
+	now := time.Date(2020, 5, 1, 4, 30, 0, 0, time.UTC)
+	value := now.In(dbLoc).Format("2006-01-02 15:04:05.999999")
+	e := &ormTime{}
+	assert.NilError(t, BuildStruct(e, map[string]string{"ctime": value, "mtime": value, "count": "3"}))
+	assert.Assert(t, e.Ctime.Equal(now), e.Ctime)
+	assert.Equal(t, e.Mtime.Valid, true)
+	assert.Assert(t, e.Mtime.Time.Equal(now), e.Mtime.Time)
+	assert.Equal(t, e.Count.Int64, int64(3))
+
+	assert.NilError(t, BuildStruct(e, map[string]string{}))
+	assert.Equal(t, e.Mtime.Valid, false)
+	assert.Equal(t, e.Count.Valid, false)
+}
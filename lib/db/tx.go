@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/neil-peng/gomvc/apperr"
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/neil-peng/gomvc/utils"
+)
+
+//事务，内嵌的DbQuery在事务内执行，用法与DbQuery一致；
+//Begin/Commit/Rollback可嵌套，嵌套层通过savepoint实现
+type Tx struct {
+	*DbQuery
+	tx    *sql.Tx
+	depth int
+	done  bool
+}
+
+//dbv内嵌*utils.Context时事务注册到请求上下文，请求结束时未结束的事务自动提交或回滚
+type txScope interface {
+	AddTransaction(tx utils.Transaction)
+}
+
+func Begin(dbv DbViewer) (*Tx, error) {
+	d := New(dbv)
+	if d.db == nil {
+		utils.Critical("logid:%v, begin tx fail, no db for table view:%s", dbv.LogId(), dbv.GetTableView())
+		return nil, apperr.New(conf.ERROR_DB_CONNECT_ERROR)
+	}
+	sqlTx, err := d.db.mysqlIns.BeginTx(d.ctx(), nil)
+	if err != nil {
+		utils.Warn("logid:%v, begin tx fail, err:%v", dbv.LogId(), err)
+		return nil, d.errno(err, conf.ERROR_DB_CONNECT_ERROR)
+	}
+	d.exec = sqlTx
+	t := &Tx{
+		DbQuery: d,
+		tx:      sqlTx,
+	}
+	if scope, ok := dbv.(txScope); ok {
+		scope.AddTransaction(t)
+	}
+	utils.Info("logid:%v, begin tx, table view:%s", dbv.LogId(), dbv.GetTableView())
+	return t, nil
+}
+
+//在事务内操作其他表视图，表视图须与事务属于同一db集群
+func (t *Tx) New(dbv DbViewer) *DbQuery {
+	d := New(dbv)
+	if d.db != t.db {
+		utils.Warn("logid:%v, table view:%s not in tx cluster:%s", dbv.LogId(),
+			dbv.GetTableView(), t.db.cluster.Db_cluster_tag)
+	}
+	d.db = t.db
+	d.exec = t.tx
+	return d
+}
+
+//开启嵌套事务(savepoint)
+func (t *Tx) Begin() error {
+	t.depth++
+	if err := t.savepoint("SAVEPOINT"); err != nil {
+		t.depth--
+		return err
+	}
+	return nil
+}
+
+func (t *Tx) Commit() error {
+	if t.depth > 0 {
+		defer func() { t.depth-- }()
+		return t.savepoint("RELEASE SAVEPOINT")
+	}
+	t.done = true
+	if err := t.tx.Commit(); err != nil {
+		utils.Warn("logid:%v, commit tx fail, err:%v", t.dbv.LogId(), err)
+		return apperr.New(conf.ERROR_DB_QUERY_ERROR)
+	}
+	utils.Info("logid:%v, commit tx", t.dbv.LogId())
+	return nil
+}
+
+func (t *Tx) Rollback() error {
+	if t.depth > 0 {
+		defer func() { t.depth-- }()
+		return t.savepoint("ROLLBACK TO SAVEPOINT")
+	}
+	t.done = true
+	if err := t.tx.Rollback(); err != nil {
+		utils.Warn("logid:%v, rollback tx fail, err:%v", t.dbv.LogId(), err)
+		return apperr.New(conf.ERROR_DB_QUERY_ERROR)
+	}
+	utils.Info("logid:%v, rollback tx", t.dbv.LogId())
+	return nil
+}
+
+//实现utils.Transaction；有未结束的嵌套层时视为失败，整体回滚
+func (t *Tx) End(err error) error {
+	if t.done {
+		return nil
+	}
+	if err == nil && t.depth == 0 {
+		return t.Commit()
+	}
+	t.depth = 0
+	return t.Rollback()
+}
+
+func (t *Tx) savepoint(action string) error {
+	sqlStr := fmt.Sprintf("%s sp_%d", action, t.depth)
+	if _, err := t.tx.ExecContext(t.ctx(), sqlStr); err != nil {
+		utils.Warn("logid:%v, %s fail, err:%v", t.dbv.LogId(), sqlStr, err)
+		return t.errno(err, conf.ERROR_DB_QUERY_ERROR)
+	}
+	utils.Info("logid:%v, %s", t.dbv.LogId(), sqlStr)
+	return nil
+}
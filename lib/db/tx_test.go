@@ -0,0 +1,99 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/neil-peng/gomvc/utils"
+	"gotest.tools/assert"
+)
+
+//内嵌*utils.Context的表视图，事务注册到请求上下文
+type txView struct {
+	fakeView
+	*utils.Context
+}
+
+func (v *txView) LogId() string {
+	return v.fakeView.LogId()
+}
+
+func TestTxSavepoint(t *testing.T) {
+	newFakeCluster(t, "tx_savepoint", "master")
+	tx, err := Begin(&fakeView{"tx_savepoint"})
+	assert.NilError(t, err)
+
+	_, err = tx.RawExeccSql("INSERT INTO tx_savepoint (id) VALUES (1)")
+	assert.NilError(t, err)
+	assert.NilError(t, tx.Begin())
+	_, err = tx.RawExeccSql("INSERT INTO tx_savepoint (id) VALUES (2)")
+	assert.NilError(t, err)
+	assert.NilError(t, tx.Begin())
+	assert.NilError(t, tx.Rollback())
+	assert.NilError(t, tx.Commit())
+	assert.NilError(t, tx.Commit())
+	//已结束的事务End不再执行
+	assert.NilError(t, tx.End(errors.New("fail")))
+
+	assert.DeepEqual(t, fakeDb.take("master"), []string{
+		"BEGIN",
+		"INSERT INTO tx_savepoint (id) VALUES (1)",
+		"SAVEPOINT sp_1",
+		"INSERT INTO tx_savepoint (id) VALUES (2)",
+		"SAVEPOINT sp_2",
+		"ROLLBACK TO SAVEPOINT sp_2",
+		"RELEASE SAVEPOINT sp_1",
+		"COMMIT",
+	})
+}
+
+func TestTxEnd(t *testing.T) {
+	newFakeCluster(t, "tx_end", "master")
+	v := &fakeView{"tx_end"}
+
+	tx, err := Begin(v)
+	assert.NilError(t, err)
+	assert.NilError(t, tx.End(nil))
+	assert.DeepEqual(t, fakeDb.take("master"), []string{"BEGIN", "COMMIT"})
+
+	tx, err = Begin(v)
+	assert.NilError(t, err)
+	assert.NilError(t, tx.End(errors.New("fail")))
+	assert.DeepEqual(t, fakeDb.take("master"), []string{"BEGIN", "ROLLBACK"})
+
+	//有未结束的嵌套层时整体回滚
+	tx, err = Begin(v)
+	assert.NilError(t, err)
+	assert.NilError(t, tx.Begin())
+	assert.NilError(t, tx.End(nil))
+	assert.DeepEqual(t, fakeDb.take("master"), []string{"BEGIN", "SAVEPOINT sp_1", "ROLLBACK"})
+}
+
+func TestTxRollbackOnPanic(t *testing.T) {
+	newFakeCluster(t, "tx_panic", "master")
+	v := &txView{fakeView: fakeView{"tx_panic"}, Context: &utils.Context{}}
+
+	//同action中finish的处理
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				v.EndTransactions(fmt.Errorf("panic:%v", r))
+			}
+		}()
+		tx, err := Begin(v)
+		assert.NilError(t, err)
+		_, err = tx.RawExeccSql("DELETE FROM tx_panic WHERE id=?", 1)
+		assert.NilError(t, err)
+		panic("boom")
+	}()
+
+	assert.DeepEqual(t, fakeDb.take("master"), []string{
+		"BEGIN",
+		"DELETE FROM tx_panic WHERE id=?",
+		"ROLLBACK",
+	})
+	//已回滚的事务不再重复结束
+	assert.NilError(t, v.EndTransactions(nil))
+	assert.Equal(t, len(fakeDb.take("master")), 0)
+}
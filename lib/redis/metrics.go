@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/neil-peng/gomvc/utils"
+)
+
+var (
+	commandDuration = utils.NewHistogram("gomvc_redis_command_duration_seconds",
+		"Redis command latency by pool and command.", nil, "pool", "command")
+	commandErrors = utils.NewCounter("gomvc_redis_command_errors_total",
+		"Failed redis commands by pool and command, nil replies excluded.", "pool", "command")
+
+	activeConns = utils.NewGauge("gomvc_redis_active_connections", "Active connections, redis.Pool.ActiveCount.", "pool")
+	idleConns   = utils.NewGauge("gomvc_redis_idle_connections", "Idle connections, redis.Pool.IdleCount.", "pool")
+)
+
+func init() {
+	utils.OnMetricsCollect(collectPoolStats)
+}
+
+//只输出当前存在的连接池
+func collectPoolStats() {
+	namedRedisPool.Lock()
+	pools := make(map[string]*RedisPool, len(namedRedisPool._redisPoolMap))
+	for name, pool := range namedRedisPool._redisPoolMap {
+		pools[name] = pool
+	}
+	namedRedisPool.Unlock()
+
+	activeConns.Reset()
+	idleConns.Reset()
+	for name, pool := range pools {
+		activeConns.Set(float64(pool._pool.ActiveCount()), name)
+		idleConns.Set(float64(pool._pool.IdleCount()), name)
+	}
+}
+
+func (r *Redis) observe(cmd string, start time.Time, err error) {
+	cmd = strings.ToUpper(cmd)
+	commandDuration.ObserveDuration(time.Since(start), r._name, cmd)
+	if err != nil && err != redis.ErrNil {
+		commandErrors.Inc(r._name, cmd)
+	}
+}
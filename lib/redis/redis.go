@@ -1,20 +1,23 @@
 package redis
 
 import (
-	"errors"
+	"context"
+	"net"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
 	"github.com/neil-peng/gomvc/utils"
 )
 
 type RedisPool struct {
-	_pool *redis.Pool
-	_addr string
+	_pool    *redis.Pool
+	_addr    string
+	_unwatch func()
 }
 
 type Redis struct {
@@ -34,12 +37,16 @@ func init() {
 	namedRedisPool = &NamedRedisPool{
 		_redisPoolMap: map[string]*RedisPool{},
 	}
+	utils.OnShutdown("redis", utils.SHUTDOWN_ORDER_REDIS, func(ctx context.Context) error {
+		return namedRedisPool.closeAll()
+	})
 }
 
 func (n *NamedRedisPool) addPool(name string, pool *RedisPool) {
 	n.Lock()
 	defer n.Unlock()
 	n._redisPoolMap[name] = pool
+	utils.RegisterHealthCheck("redis:"+name, pool.ping)
 }
 
 func (n *NamedRedisPool) getPool(name string) *RedisPool {
@@ -52,6 +59,60 @@ func (n *NamedRedisPool) removePool(name string) {
 	n.Lock()
 	defer n.Unlock()
 	delete(n._redisPoolMap, name)
+	utils.UnregisterHealthCheck("redis:" + name)
+}
+
+//关闭并移除连接池，name对应的已是其他连接池时忽略
+func (n *NamedRedisPool) closePool(name string, pool *RedisPool) {
+	n.Lock()
+	if n._redisPoolMap[name] != pool {
+		n.Unlock()
+		return
+	}
+	delete(n._redisPoolMap, name)
+	n.Unlock()
+	utils.UnregisterHealthCheck("redis:" + name)
+
+	if pool._unwatch != nil {
+		pool._unwatch()
+	}
+	pool._pool.Close()
+}
+
+//关闭全部连接池，进程退出时调用
+func (n *NamedRedisPool) closeAll() error {
+	n.Lock()
+	pools := n._redisPoolMap
+	n._redisPoolMap = map[string]*RedisPool{}
+	n.Unlock()
+
+	var lastErr error
+	for name, pool := range pools {
+		utils.UnregisterHealthCheck("redis:" + name)
+		if pool._unwatch != nil {
+			pool._unwatch()
+		}
+		if err := pool._pool.Close(); err != nil {
+			utils.Warn("close redis pool fail, service:%s, err:%v", name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+//健康检查
+func (p *RedisPool) ping(ctx context.Context) error {
+	conn, err := p._pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	timeout := time.Duration(conf.REDIS_READ_TIMEOUTMS) * time.Millisecond
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	_, err = redis.DoWithTimeout(conn, timeout, "PING")
+	return err
 }
 
 func (r *Redis) Name(redisServiceName string) *Redis {
@@ -72,8 +133,7 @@ func (r *Redis) createNamePool(redisServiceName string, isFresh bool) error {
 			return nil
 		}
 		if r._redis == rp {
-			rp._pool.Close()
-			namedRedisPool.removePool(redisServiceName)
+			namedRedisPool.closePool(redisServiceName, rp)
 			r.Info("close redis pool, addr:%s", rp._addr)
 		} else {
 			r.Info("ignore competition redis pool, reuse addr:%s", rp._addr)
@@ -86,6 +146,8 @@ func (r *Redis) createNamePool(redisServiceName string, isFresh bool) error {
 		r.Critical("init redis pool failed, service:%s, err:%v", redisServiceName, err)
 		return err
 	}
+	nameService := r.GetNameService()
+	utils.ReleaseServer(nameService, net.JoinHostPort(defaultIp, defaultPort))
 	pool := &redis.Pool{
 		MaxIdle:     conf.REDIS_POOL_INT_MAX_IDLE_NUMS,
 		MaxActive:   conf.REDIS_POOL_INT_MAX_ACTIVE_NUMS,
@@ -97,11 +159,21 @@ func (r *Redis) createNamePool(redisServiceName string, isFresh bool) error {
 				ip = defaultIp
 				port = defaultPort
 			}
-			address := ip + ":" + port
-			c, err := redis.DialTimeout("tcp", address,
-				conf.REDIS_CONNECT_TIMEOUTMS*time.Millisecond,
-				conf.REDIS_READ_TIMEOUTMS*time.Millisecond,
-				conf.REDIS_WRITE_TIMEOUTMS*time.Millisecond)
+			address := net.JoinHostPort(ip, port)
+			start := time.Now()
+			//连接上命令的网络错误也上报，摘除能连接但无法处理命令的实例
+			c, err := redis.Dial("tcp", address,
+				redis.DialConnectTimeout(conf.REDIS_CONNECT_TIMEOUTMS*time.Millisecond),
+				redis.DialReadTimeout(conf.REDIS_READ_TIMEOUTMS*time.Millisecond),
+				redis.DialWriteTimeout(conf.REDIS_WRITE_TIMEOUTMS*time.Millisecond),
+				redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+					conn, err := net.DialTimeout(network, addr, conf.REDIS_CONNECT_TIMEOUTMS*time.Millisecond)
+					if err != nil {
+						return nil, err
+					}
+					return utils.ReportConn(nameService, addr, conn), nil
+				}))
+			utils.ReportResult(nameService, address, err, time.Since(start))
 			if err != nil {
 				r.Warn("redis dail %s failed", address)
 				return nil, err
@@ -119,36 +191,102 @@ func (r *Redis) createNamePool(redisServiceName string, isFresh bool) error {
 	}
 	redisPool = &RedisPool{}
 	redisPool._pool = pool
+	//创建时解析到的地址，用于日志和span
+	redisPool._addr = net.JoinHostPort(defaultIp, defaultPort)
 
 	if rp := namedRedisPool.getPool(redisServiceName); rp != nil {
 		redisPool = rp
 	} else {
 		namedRedisPool.addPool(redisServiceName, redisPool)
+		//实例变更后关闭连接池，下次使用时按新实例重建
+		if registry, ok := r.GetNameService().(utils.Registry); ok {
+			pool := redisPool
+			pool._unwatch = registry.Watch(redisServiceName, func(service string, instances []*utils.Instance) {
+				utils.Notice("redis instances changed, close pool, service:%s, instances:%d", service, len(instances))
+				namedRedisPool.closePool(service, pool)
+			})
+		}
 	}
+	r._redis = redisPool
 
 	r.Info("init redis pool succ, service:%s", redisServiceName)
 	return nil
 }
 
+//请求超时或取消引起的失败不重建连接池
+func (r *Redis) reconnect() {
+	if r.Canceled() {
+		return
+	}
+	r.createNamePool(r._name, true)
+}
+
+//从连接池取连接，等待连接时随请求超时或取消而返回
+func (r *Redis) getConn() redis.Conn {
+	conn, _ := r._redis._pool.GetContext(r.StdContext())
+	return conn
+}
+
+//命令超时取读超时与请求剩余时间的较小值
+func (r *Redis) do(conn redis.Conn, cmd string, args ...interface{}) (reply interface{}, err error) {
+	start := time.Now()
+	span := r.startSpan(cmd)
+	defer func() {
+		r.observe(cmd, start, err)
+		endSpan(span, err)
+	}()
+	timeout := conf.REDIS_READ_TIMEOUTMS * time.Millisecond
+	if deadline, ok := r.StdContext().Deadline(); ok {
+		if remain := time.Until(deadline); remain < timeout {
+			timeout = remain
+		}
+	}
+	if timeout <= 0 {
+		return nil, r.StdContext().Err()
+	}
+	return redis.DoWithTimeout(conn, timeout, cmd, args...)
+}
+
+//命令的span，不含参数
+func (r *Redis) startSpan(cmd string) *utils.Span {
+	cmd = strings.ToUpper(cmd)
+	span := r.StartSpan("redis "+cmd, utils.SPAN_KIND_CLIENT)
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("db.operation", cmd)
+	span.SetAttr("peer.service", r._name)
+	if r._redis != nil {
+		span.SetAttr("server.address", r._redis._addr)
+	}
+	return span
+}
+
+//nil回复不记为失败
+func endSpan(span *utils.Span, err error) {
+	if err == redis.ErrNil {
+		err = nil
+	}
+	span.End(err)
+}
+
 func (r *Redis) get(key string) (string, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("get connection failed, active nums:%d, error:%s",
 			r._redis._pool.ActiveCount(), err)
-		r.createNamePool(r._name, true)
-		return "", errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return "", apperr.New(conf.ERROR_CONN_CACHE)
 	}
 
-	res, err := conn.Do("GET", key)
+	res, err := r.do(conn, "GET", key)
 	if err != nil {
 		r.Warn("[do get failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return "", errors.New(conf.ERROR_GET_CACHE)
+		r.reconnect()
+		return "", apperr.New(conf.ERROR_GET_CACHE)
 	}
 
 	v, err := redis.String(res, err)
@@ -163,12 +301,12 @@ func (r *Redis) get(key string) (string, error) {
 			key, r._redis._pool.ActiveCount())
 		return "", nil
 	}
-	return "", errors.New(conf.ERROR_GET_CACHE)
+	return "", apperr.New(conf.ERROR_GET_CACHE)
 }
 
 func (r *Redis) Get(key string) (string, error) {
 	if r._redis == nil {
-		return "", errors.New(conf.ERROR_CONN_CACHE)
+		return "", apperr.New(conf.ERROR_CONN_CACHE)
 	}
 
 	var res string
@@ -178,6 +316,10 @@ func (r *Redis) Get(key string) (string, error) {
 		if err == nil {
 			return res, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[get key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -188,20 +330,20 @@ func (r *Redis) set(key string, value string) error {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("SET", key, value)
+	res, err := r.do(conn, "SET", key, value)
 	if err != nil {
 		r.Warn("[do set failed, reconnect [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.String(res, err)
@@ -210,12 +352,12 @@ func (r *Redis) set(key string, value string) error {
 			key, v, r._redis._pool.ActiveCount())
 		return nil
 	}
-	return errors.New(conf.ERROR_SET_CACHE)
+	return apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Set(key string, value string) error {
 	if r._redis == nil {
-		return errors.New(conf.ERROR_CONN_CACHE)
+		return apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	for i := 0; i < conf.RETRY; i++ {
@@ -223,6 +365,10 @@ func (r *Redis) Set(key string, value string) error {
 		if err == nil {
 			return nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[set key:%s failed] [value:%s] [error:%s] [active nums:%d]",
 		key, value, err, r._redis._pool.ActiveCount())
@@ -233,20 +379,20 @@ func (r *Redis) setEx(key string, expire int, value string) error {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("SETEX", key, expire, value)
+	res, err := r.do(conn, "SETEX", key, expire, value)
 	if err != nil {
 		r.Warn("[do setex failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.String(res, err)
@@ -258,12 +404,12 @@ func (r *Redis) setEx(key string, expire int, value string) error {
 
 	r.Info("[setex key:%s failed] [value:%s] [error:%s] [active nums:%d]",
 		key, value, err, r._redis._pool.ActiveCount())
-	return errors.New(conf.ERROR_SET_CACHE)
+	return apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) SetEx(key string, expire int, value string) error {
 	if r._redis == nil {
-		return errors.New(conf.ERROR_CONN_CACHE)
+		return apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	for i := 0; i < conf.RETRY; i++ {
@@ -271,6 +417,10 @@ func (r *Redis) SetEx(key string, expire int, value string) error {
 		if err == nil {
 			return nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[setex key:%s failed] [expire:%d] [value:%s] [error:%s] [active nums:%d]",
 		key, expire, value, err, r._redis._pool.ActiveCount())
@@ -281,21 +431,21 @@ func (r *Redis) setNx(key string, value ...interface{}) (error, int) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_CONN_CACHE), -1
+		r.reconnect()
+		return apperr.New(conf.ERROR_CONN_CACHE), -1
 	}
 
-	res, err := conn.Do("SET", append([]interface{}{key}, value...)...)
+	res, err := r.do(conn, "SET", append([]interface{}{key}, value...)...)
 	if err != nil {
 		r.Warn("[do setnx failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_SET_CACHE), -1
+		r.reconnect()
+		return apperr.New(conf.ERROR_SET_CACHE), -1
 	}
 
 	if res == nil && err == nil {
@@ -310,19 +460,24 @@ func (r *Redis) setNx(key string, value ...interface{}) (error, int) {
 
 	r.Info("[setnx key:%s failed] [value:%s] [error:%s] [active nums:%d]",
 		key, value, err, r._redis._pool.ActiveCount())
-	return errors.New(conf.ERROR_SET_CACHE), -1
+	return apperr.New(conf.ERROR_SET_CACHE), -1
 }
 
 func (r *Redis) SetNx(key string, value ...interface{}) (error, int) {
 	if r._redis == nil {
-		return errors.New(conf.ERROR_CONN_CACHE), -1
+		return apperr.New(conf.ERROR_CONN_CACHE), -1
 	}
 	var err error
 	for i := 0; i < conf.RETRY; i++ {
-		err, res := r.setNx(key, value...)
+		var res int
+		err, res = r.setNx(key, value...)
 		if err == nil {
 			return nil, res
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[setnx key:%s failed] [value:%s] [error:%s] [active nums:%d]",
 		key, value, err, r._redis._pool.ActiveCount())
@@ -333,20 +488,20 @@ func (r *Redis) expire(key string, timeout int) (int, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("EXPIRE", key, timeout)
+	res, err := r.do(conn, "EXPIRE", key, timeout)
 	if err != nil {
 		r.Warn("[do expire failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_SET_CACHE)
 
 	}
 
@@ -359,12 +514,12 @@ func (r *Redis) expire(key string, timeout int) (int, error) {
 
 	r.Info("[expire key:%s failed] [expire:%d] [error:%s] [active nums:%d]",
 		key, timeout, err, r._redis._pool.ActiveCount())
-	return 0, errors.New(conf.ERROR_GET_CACHE)
+	return 0, apperr.New(conf.ERROR_GET_CACHE)
 }
 
 func (r *Redis) Expire(key string, timeout int) (int, error) {
 	if r._redis == nil {
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v int
@@ -373,6 +528,10 @@ func (r *Redis) Expire(key string, timeout int) (int, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[expire key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -383,20 +542,20 @@ func (r *Redis) del(key string) error {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("DEL", key)
+	res, err := r.do(conn, "DEL", key)
 	if err != nil {
 		r.Warn("[do del failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	_, err = redis.Int(res, err)
@@ -408,12 +567,12 @@ func (r *Redis) del(key string) error {
 
 	r.Info("[del key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return errors.New(conf.ERROR_SET_CACHE)
+	return apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Del(key string) error {
 	if r._redis == nil {
-		return errors.New(conf.ERROR_CONN_CACHE)
+		return apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	for i := 0; i < conf.RETRY; i++ {
@@ -421,6 +580,10 @@ func (r *Redis) Del(key string) error {
 		if err == nil {
 			return nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[del key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -431,20 +594,20 @@ func (r *Redis) incrBy(key string, value int) (int, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("INCRBY", key, value)
+	res, err := r.do(conn, "INCRBY", key, value)
 	if err != nil {
 		r.Warn("[do incrby failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.Int(res, err)
@@ -456,12 +619,12 @@ func (r *Redis) incrBy(key string, value int) (int, error) {
 
 	r.Info("[incr key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return -1, errors.New(conf.ERROR_SET_CACHE)
+	return -1, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) IncrBy(key string, value int) (int, error) {
 	if r._redis == nil {
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v int
@@ -470,6 +633,10 @@ func (r *Redis) IncrBy(key string, value int) (int, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[incrby key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -480,20 +647,20 @@ func (r *Redis) incr(key string) (int, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Critical("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("INCR", key)
+	res, err := r.do(conn, "INCR", key)
 	if err != nil {
 		r.Warn("[do incr failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.Int(res, err)
@@ -505,12 +672,12 @@ func (r *Redis) incr(key string) (int, error) {
 
 	r.Info("[incr key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return -1, errors.New(conf.ERROR_SET_CACHE)
+	return -1, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Incr(key string) (int, error) {
 	if r._redis == nil {
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v int
@@ -519,6 +686,10 @@ func (r *Redis) Incr(key string) (int, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Warn("[incr key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -529,20 +700,20 @@ func (r *Redis) ttl(key string) (int, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("TTL", key)
+	res, err := r.do(conn, "TTL", key)
 	if err != nil {
 		r.Warn("[do ttl failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.Int(res, err)
@@ -554,12 +725,12 @@ func (r *Redis) ttl(key string) (int, error) {
 
 	r.Warn("[ttl key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return -1, errors.New(conf.ERROR_SET_CACHE)
+	return -1, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Ttl(key string) (int, error) {
 	if r._redis == nil {
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v int
@@ -568,6 +739,10 @@ func (r *Redis) Ttl(key string) (int, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[ttl key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -578,20 +753,20 @@ func (r *Redis) rpush(key string, value ...interface{}) (int, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("rpush", append([]interface{}{key}, value...)...)
+	res, err := r.do(conn, "rpush", append([]interface{}{key}, value...)...)
 	if err != nil {
 		r.Warn("[do rpush failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.Int(res, err)
@@ -603,12 +778,12 @@ func (r *Redis) rpush(key string, value ...interface{}) (int, error) {
 
 	r.Warn("[rpush key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return -1, errors.New(conf.ERROR_SET_CACHE)
+	return -1, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Rpush(key string, value ...interface{}) (int, error) {
 	if r._redis == nil {
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v int
@@ -617,6 +792,10 @@ func (r *Redis) Rpush(key string, value ...interface{}) (int, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[rpush key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -627,20 +806,20 @@ func (r *Redis) lrange(key string, start int, end int) ([]string, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return nil, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return nil, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("lrange", key, start, end)
+	res, err := r.do(conn, "lrange", key, start, end)
 	if err != nil {
 		r.Warn("[do lrange failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return nil, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return nil, apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.Strings(res, err)
@@ -652,12 +831,12 @@ func (r *Redis) lrange(key string, start int, end int) ([]string, error) {
 
 	r.Warn("[lrange key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return nil, errors.New(conf.ERROR_SET_CACHE)
+	return nil, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Lrange(key string, start int, end int) ([]string, error) {
 	if r._redis == nil {
-		return nil, errors.New(conf.ERROR_CONN_CACHE)
+		return nil, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v []string
@@ -666,6 +845,10 @@ func (r *Redis) Lrange(key string, start int, end int) ([]string, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[lrange key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -676,28 +859,32 @@ func (r *Redis) script(keyCount int, luaScript string, keysAndArgs ...interface{
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	s := redis.NewScript(keyCount, luaScript)
+	start := time.Now()
+	span := r.startSpan("EVALSHA")
 	v, err := s.Do(conn, keysAndArgs...)
+	r.observe("EVALSHA", start, err)
+	endSpan(span, err)
 	if err != nil {
 		r.Warn("[do script failed, reconnect] [luaScript:%s] [args:%+v] [error:%s] [active nums:%d]",
 			luaScript, keysAndArgs, err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return nil, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return nil, apperr.New(conf.ERROR_SET_CACHE)
 	}
 	return v, nil
 }
 
 func (r *Redis) Script(keyCount int, luaScript string, keysAndArgs ...interface{}) (interface{}, error) {
 	if r._redis == nil {
-		return nil, errors.New(conf.ERROR_CONN_CACHE)
+		return nil, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v interface{}
@@ -706,6 +893,10 @@ func (r *Redis) Script(keyCount int, luaScript string, keysAndArgs ...interface{
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[script:%s failed] [error:%s] [active nums:%d]",
 		luaScript, err, r._redis._pool.ActiveCount())
@@ -716,20 +907,20 @@ func (r *Redis) hset(key string, field string, value string) (int, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("HSET", key, field, value)
+	res, err := r.do(conn, "HSET", key, field, value)
 	if err != nil {
 		r.Warn("[do hset failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.Int(res, err)
@@ -741,12 +932,12 @@ func (r *Redis) hset(key string, field string, value string) (int, error) {
 
 	r.Warn("[hset key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return -1, errors.New(conf.ERROR_SET_CACHE)
+	return -1, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Hset(key string, field string, value string) (int, error) {
 	if r._redis == nil {
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v int
@@ -755,6 +946,10 @@ func (r *Redis) Hset(key string, field string, value string) (int, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[hset key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -765,20 +960,20 @@ func (r *Redis) hget(key string, field string) (string, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return "", errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return "", apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("HGET", key, field)
+	res, err := r.do(conn, "HGET", key, field)
 	if err != nil {
 		r.Warn("[do hget failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return "", errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return "", apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.String(res, err)
@@ -790,12 +985,12 @@ func (r *Redis) hget(key string, field string) (string, error) {
 
 	r.Warn("[hget key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return "", errors.New(conf.ERROR_SET_CACHE)
+	return "", apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Hget(key string, field string) (string, error) {
 	if r._redis == nil {
-		return "", errors.New(conf.ERROR_CONN_CACHE)
+		return "", apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v string
@@ -804,6 +999,10 @@ func (r *Redis) Hget(key string, field string) (string, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[hget key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -814,20 +1013,20 @@ func (r *Redis) hgetall(key string) ([]string, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return nil, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return nil, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("HGETALL", key)
+	res, err := r.do(conn, "HGETALL", key)
 	if err != nil {
 		r.Warn("[do hgetall failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return nil, errors.New(conf.ERROR_GET_CACHE)
+		r.reconnect()
+		return nil, apperr.New(conf.ERROR_GET_CACHE)
 	}
 
 	v, err := redis.Strings(res, err)
@@ -839,12 +1038,12 @@ func (r *Redis) hgetall(key string) ([]string, error) {
 
 	r.Warn("[hgetall key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return nil, errors.New(conf.ERROR_SET_CACHE)
+	return nil, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Hgetall(key string) ([]string, error) {
 	if r._redis == nil {
-		return nil, errors.New(conf.ERROR_CONN_CACHE)
+		return nil, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v []string
@@ -853,6 +1052,10 @@ func (r *Redis) Hgetall(key string) ([]string, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[hgetall key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
@@ -863,20 +1066,20 @@ func (r *Redis) hdel(key string, field string) (int, error) {
 	r.StatusStart()
 	defer r.StatusEnd()
 
-	conn := r._redis._pool.Get()
+	conn := r.getConn()
 	defer conn.Close()
 	if err := conn.Err(); err != nil {
 		r.Warn("[get connection failed] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
-	res, err := conn.Do("HDEL", key, field)
+	res, err := r.do(conn, "HDEL", key, field)
 	if err != nil {
 		r.Warn("[do hdel failed, reconnect] [error:%s] [active nums:%d]",
 			err, r._redis._pool.ActiveCount())
-		r.createNamePool(r._name, true)
-		return -1, errors.New(conf.ERROR_SET_CACHE)
+		r.reconnect()
+		return -1, apperr.New(conf.ERROR_SET_CACHE)
 	}
 
 	v, err := redis.Int(res, err)
@@ -888,12 +1091,12 @@ func (r *Redis) hdel(key string, field string) (int, error) {
 
 	r.Warn("[hdel key %s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
-	return -1, errors.New(conf.ERROR_SET_CACHE)
+	return -1, apperr.New(conf.ERROR_SET_CACHE)
 }
 
 func (r *Redis) Hdel(key string, field string) (int, error) {
 	if r._redis == nil {
-		return -1, errors.New(conf.ERROR_CONN_CACHE)
+		return -1, apperr.New(conf.ERROR_CONN_CACHE)
 	}
 	var err error
 	var v int
@@ -902,6 +1105,10 @@ func (r *Redis) Hdel(key string, field string) (int, error) {
 		if err == nil {
 			return v, nil
 		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
 	}
 	r.Critical("[hdel key:%s failed] [error:%s] [active nums:%d]",
 		key, err, r._redis._pool.ActiveCount())
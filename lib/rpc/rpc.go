@@ -1 +1,217 @@
 package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/neil-peng/gomvc/apperr"
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/neil-peng/gomvc/utils"
+)
+
+const (
+	contentTypeForm = "application/x-www-form-urlencoded"
+	contentTypeJson = "application/json"
+)
+
+//调用下游gomvc服务，例：
+//(&rpc.Rpc{Context: ctx, Service: "example"}).Get("/rest/example/get", url.Values{"key": {"k"}}, &res)
+type Rpc struct {
+	*utils.Context
+	Service   string //交给NameService解析的服务名
+	TimeoutMs int    //单次请求超时，默认conf.RPC_TIMEOUTMS
+	Retry     int    //网络失败的尝试次数，默认conf.RETRY
+}
+
+//下游返回的非0 error_code，Error()为下游errno
+type Error struct {
+	Service string
+	Code    string
+	Msg     string
+	LogId   string
+}
+
+func (e *Error) Error() string {
+	return e.Code
+}
+
+//gomvc返回值的公共字段
+type envelope struct {
+	ErrCode string `json:"error_code"`
+	ErrMsg  string `json:"error_msg"`
+	LogId   string `json:"log_id"`
+}
+
+var client = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: conf.RPC_CONNECT_TIMEOUTMS * time.Millisecond,
+		}).DialContext,
+		MaxIdleConns:        conf.RPC_MAX_IDLE_CONNS,
+		MaxIdleConnsPerHost: conf.RPC_MAX_IDLE_CONNS,
+		IdleConnTimeout:     conf.RPC_IDLE_CONN_TIMEOUTS * time.Second,
+	},
+}
+
+func (r *Rpc) Get(path string, params url.Values, result interface{}) error {
+	return r.Call(http.MethodGet, path, params, nil, "", result)
+}
+
+func (r *Rpc) Post(path string, params url.Values, result interface{}) error {
+	return r.Call(http.MethodPost, path, nil, []byte(params.Encode()), contentTypeForm, result)
+}
+
+func (r *Rpc) PostJson(path string, body interface{}, result interface{}) error {
+	data, err := utils.JSONEncode(body)
+	if err != nil {
+		r.Warn("rpc encode body fail, service:%s, path:%s, err:%v", r.Service, path, err)
+		return apperr.New(conf.ERROR_PARAM_ERROR)
+	}
+	return r.Call(http.MethodPost, path, nil, data, contentTypeJson, result)
+}
+
+//发起调用并将返回值解析到result；下游业务错误返回*Error，不重试；
+//超时等下游可能已处理的失败只重试GET/HEAD/PUT/DELETE，其他方法只重试连接失败
+func (r *Rpc) Call(method, path string, query url.Values, body []byte, contentType string, result interface{}) error {
+	r.StatusStart()
+	defer r.StatusEnd()
+
+	retry := r.Retry
+	if retry <= 0 {
+		retry = conf.RETRY
+	}
+	var data []byte
+	var err error
+	var retriable bool
+	for i := 0; i < retry; i++ {
+		data, retriable, err = r.call(method, path, query, body, contentType)
+		if err == nil || !retriable {
+			break
+		}
+		if r.Canceled() {
+			err = apperr.New(conf.ERROR_TIMEOUT)
+			break
+		}
+		r.Warn("rpc retry, service:%s, path:%s, try:%d, err:%v", r.Service, path, i+1, err)
+	}
+	if err != nil {
+		return err
+	}
+	return r.decode(path, data, result)
+}
+
+func (r *Rpc) call(method, path string, query url.Values, body []byte, contentType string) (data []byte, retriable bool, err error) {
+	ip, port, err := r.GetServer(r.Service)
+	if err != nil {
+		r.Warn("rpc get server fail, service:%s, err:%v", r.Service, err)
+		return nil, true, apperr.New(conf.ERROR_NAMESERVICE_ERROR)
+	}
+
+	params := url.Values{}
+	for k, v := range query {
+		params[k] = v
+	}
+	params.Set("logid", r.LogId())
+	addr := net.JoinHostPort(ip, port)
+	reqUrl := "http://" + addr + path + "?" + params.Encode()
+
+	//每次尝试一个span，下游以其为父节点
+	span := r.StartSpan(method+" "+r.Service+path, utils.SPAN_KIND_CLIENT)
+	span.SetAttr("http.method", method)
+	span.SetAttr("http.url", path)
+	span.SetAttr("peer.service", r.Service)
+	span.SetAttr("server.address", addr)
+	defer func() {
+		span.End(err)
+	}()
+
+	timeoutMs := r.TimeoutMs
+	if timeoutMs <= 0 {
+		timeoutMs = conf.RPC_TIMEOUTMS
+	}
+	ctx, cancel := context.WithTimeout(r.StdContext(), time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(body))
+	if err != nil {
+		r.Warn("rpc new request fail, url:%s, err:%v", reqUrl, err)
+		utils.ReleaseServer(r.GetNameService(), addr)
+		return nil, false, apperr.New(conf.ERROR_PARAM_ERROR)
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	span.Inject(req.Header)
+
+	cost := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		utils.ReportResult(r.GetNameService(), addr, err, time.Since(cost))
+		r.Warn("rpc fail, method:%s, url:%s, cost:%dms, err:%v", method, reqUrl, time.Since(cost)/time.Millisecond, err)
+		return nil, idempotent(method) || isDialError(err), apperr.New(conf.ERROR_SERVER_NOT_ACCESS)
+	}
+	defer resp.Body.Close()
+	span.SetAttr("http.status_code", resp.StatusCode)
+	data, err = ioutil.ReadAll(resp.Body)
+	r.Info("rpc done, method:%s, url:%s, http_code:%d, len:%d, cost:%dms, err:%v",
+		method, reqUrl, resp.StatusCode, len(data), time.Since(cost)/time.Millisecond, err)
+	if err != nil {
+		utils.ReportResult(r.GetNameService(), addr, err, time.Since(cost))
+		return nil, idempotent(method), apperr.New(conf.ERROR_SERVER_NOT_ACCESS)
+	}
+	//网关类错误没有gomvc返回值，幂等请求可重试
+	if resp.StatusCode >= http.StatusBadGateway && !strings.Contains(string(data), `"error_code"`) {
+		utils.ReportResult(r.GetNameService(), addr, errors.New(resp.Status), time.Since(cost))
+		return nil, idempotent(method), apperr.New(conf.ERROR_SERVER_NOT_ACCESS)
+	}
+	utils.ReportResult(r.GetNameService(), addr, nil, time.Since(cost))
+	return data, false, nil
+}
+
+func (r *Rpc) decode(path string, data []byte, result interface{}) error {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		r.Warn("rpc decode fail, service:%s, path:%s, body:%s, err:%v", r.Service, path, data, err)
+		return apperr.New(conf.ERROR_NETWORK_ERROR)
+	}
+	if len(env.ErrCode) > 0 && env.ErrCode != conf.NO_ERROR {
+		r.Warn("rpc error, service:%s, path:%s, error_code:%s, error_msg:%s, log_id:%s",
+			r.Service, path, env.ErrCode, env.ErrMsg, env.LogId)
+		return &Error{
+			Service: r.Service,
+			Code:    env.ErrCode,
+			Msg:     env.ErrMsg,
+			LogId:   env.LogId,
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		r.Warn("rpc decode result fail, service:%s, path:%s, err:%v", r.Service, path, err)
+		return apperr.New(conf.ERROR_NETWORK_ERROR)
+	}
+	return nil
+}
+
+//下游可能已处理的失败只重试幂等请求，POST等只重试未发出请求的失败
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+//建立连接失败，请求未发出
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
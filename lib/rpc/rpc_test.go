@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/neil-peng/gomvc/utils"
+	"gotest.tools/assert"
+)
+
+func newTestRpc(server *httptest.Server) *Rpc {
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Request = httptest.NewRequest("GET", "/?logid=123", nil)
+	ctx := &utils.Context{Context: gc, Logger: utils.NewLogger()}
+	ctx.SetNameService(&utils.IpServer)
+	return &Rpc{Context: ctx, Service: strings.TrimPrefix(server.URL, "http://")}
+}
+
+func TestRpcGet(t *testing.T) {
+	var tries int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tries++
+		assert.Equal(t, req.URL.Query().Get("logid"), "123")
+		if tries == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"error_code":"0","error_msg":"success","log_id":"123","value":"` +
+			req.URL.Query().Get("key") + `"}`))
+	}))
+	defer server.Close()
+
+	var res struct{ Value string }
+	err := newTestRpc(server).Get("/rest/example/get", url.Values{"key": {"k"}}, &res)
+	assert.NilError(t, err)
+	assert.Equal(t, tries, 2)
+	assert.Equal(t, res.Value, "k")
+}
+
+func TestRpcError(t *testing.T) {
+	var tries int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tries++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error_code":"10006","error_msg":"param error","log_id":"123"}`))
+	}))
+	defer server.Close()
+
+	err := newTestRpc(server).PostJson("/rest/example/add", map[string]string{"key": "k"}, nil)
+	rpcErr, ok := err.(*Error)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, rpcErr.Error(), "10006")
+	assert.Equal(t, rpcErr.Msg, "param error")
+	assert.Equal(t, tries, 1)
+}
+
+func TestRpcTraceParent(t *testing.T) {
+	var traceParent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		traceParent = req.Header.Get(utils.TRACE_PARENT_HEADER)
+		w.Write([]byte(`{"error_code":"0"}`))
+	}))
+	defer server.Close()
+
+	r := newTestRpc(server)
+	root := r.StartTrace("GET /", http.Header{})
+	assert.NilError(t, r.Get("/rest/example/get", nil, nil))
+	traceId, parentId, _, ok := utils.ParseTraceParent(traceParent)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, traceId, root.TraceId)
+	assert.Assert(t, parentId != root.SpanId)
+}
+
+func TestRpcPostTimeoutNotRetried(t *testing.T) {
+	var tries int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&tries, 1)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"error_code":"0"}`))
+	}))
+	defer server.Close()
+
+	r := newTestRpc(server)
+	r.TimeoutMs = 50
+	err := r.Post("/rest/example/add", url.Values{"key": {"k"}}, nil)
+	assert.Assert(t, err != nil)
+	assert.Equal(t, atomic.LoadInt32(&tries), int32(1))
+
+	//幂等请求超时后重试
+	err = r.Get("/rest/example/get", nil, nil)
+	assert.Assert(t, err != nil)
+	assert.Equal(t, atomic.LoadInt32(&tries), int32(1+conf.RETRY))
+}
+
+func TestIsDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	_, err = client.Get("http://" + addr)
+	assert.Equal(t, isDialError(err), true)
+	assert.Equal(t, isDialError(context.DeadlineExceeded), false)
+}
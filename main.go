@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/neil-peng/gomvc/action"
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
 	"github.com/neil-peng/gomvc/lib/db"
 	"github.com/neil-peng/gomvc/utils"
@@ -16,6 +17,7 @@ import (
 func setupSignal() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT)
+	signal.Notify(c, syscall.SIGTERM)
 	signal.Notify(c, syscall.SIGHUP)
 	signal.Notify(c, syscall.SIGUSR1)
 	signal.Notify(c, syscall.SIGUSR2)
@@ -23,10 +25,17 @@ func setupSignal() {
 	signal.Notify(c, syscall.SIGTTOU)
 	signal.Notify(c, syscall.SIGPIPE)
 	go func() {
+		var profiling, shutting bool
 		for sig := range c {
 			utils.Warn("got sig:%v", sig)
 			switch sig {
 			case syscall.SIGUSR1:
+				if profiling {
+					utils.Notice("stop cpu pprof")
+					pprof.StopCPUProfile()
+					profiling = false
+					break
+				}
 				f, err := os.Create(conf.ApiConf.LOG_FILE_DIR + " gomvc.prof")
 				if err != nil {
 					utils.Warn("create gomvc.prof erorr")
@@ -34,9 +43,17 @@ func setupSignal() {
 				}
 				utils.Notice("start cpu pprof")
 				pprof.StartCPUProfile(f)
+				profiling = true
 			case syscall.SIGUSR2:
-				utils.Notice("stop cpu pprof")
-				pprof.StopCPUProfile()
+				if !conf.ApiConf.GRACEFUL_RESTART {
+					utils.Notice("stop cpu pprof")
+					pprof.StopCPUProfile()
+					profiling = false
+					break
+				}
+				if err := utils.Restart(); err != nil {
+					utils.Warn("restart fail, err:%v", err)
+				}
 			case syscall.SIGTTIN:
 				utils.SetLogLevel(utils.GetLogLevel() + 1)
 			case syscall.SIGTTOU:
@@ -46,9 +63,14 @@ func setupSignal() {
 				utils.ReOpen("")
 			case syscall.SIGPIPE:
 				utils.Warn("ignore sig:%v", sig)
-			case syscall.SIGINT:
-				utils.Warn("SIGINT")
-				os.Exit(0)
+			case syscall.SIGINT, syscall.SIGTERM:
+				//再次收到时不再等待
+				if shutting {
+					utils.Flush()
+					os.Exit(1)
+				}
+				shutting = true
+				utils.Shutdown()
 			}
 		}
 	}()
@@ -56,16 +78,28 @@ func setupSignal() {
 
 func Init() {
 	setupSignal()
-	utils.SetLogFile(conf.ApiConf.LOG_FILE_NAME)
+	if err := utils.InitLogSinks(); err != nil {
+		panic(err)
+	}
 	utils.SetLogLevel(conf.ApiConf.LOG_LEVEL)
+	utils.SetLogFormat(conf.ApiConf.LOG_FORMAT)
+	utils.SetLogAsync(conf.ApiConf.LOG_ASYNC_BUFFER, conf.ApiConf.LOG_OVERFLOW)
 	utils.SetLogbackupCount(48) //live: 2 days
 	utils.SetLogRotate(time.Hour)
-	db.Init(&utils.IpServer)
+	if len(conf.ApiConf.I18N_DIR) > 0 {
+		if err := apperr.LoadCatalogs(conf.ApiConf.I18N_DIR); err != nil {
+			panic(err)
+		}
+	}
+	apperr.SetDefaultLang(conf.ApiConf.I18N_DEFAULT_LANG)
+	if err := utils.InitTracer(); err != nil {
+		panic(err)
+	}
+	db.Init(utils.InitNameService())
 }
 
 func main() {
 	Init()
-	utils.AddRoute("GET", "/rest/example/add", &action.Api{}, action.AddExample)
-	utils.AddRoute("GET", "/rest/example/get", &action.Api{}, action.GetExample)
+	action.Register(utils.DefaultRouter())
 	utils.RunServer(":8080")
 }
@@ -0,0 +1,275 @@
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/utils"
+)
+
+//参数来源，tag：`from:"query|body|form|path|header"`，默认query
+const (
+	FROM_QUERY  = "query"
+	FROM_BODY   = "body"
+	FROM_FORM   = "form"
+	FROM_PATH   = "path"
+	FROM_HEADER = "header"
+)
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	fileHeaderType  = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeadersType = reflect.TypeOf([]*multipart.FileHeader(nil))
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+//绑定结构体的导出字段并校验，错误收集到errs，返回是否有字段取到值
+func (r *Request) bind(v reflect.Value, prefix string, errs *ValidError) bool {
+	t := v.Type()
+	found := false
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		req, from, name := sf.Tag.Get("req"), sf.Tag.Get("from"), sf.Tag.Get("name")
+		if len(sf.PkgPath) > 0 || (len(req) == 0 && len(from) == 0 && len(name) == 0) {
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(sf.Name)
+		}
+		if len(from) == 0 {
+			from = FROM_QUERY
+		}
+
+		key := prefix + name
+		ok, err := r.bindField(v.Field(i), from, key, errs)
+		if err != nil {
+			errs.add(key, RULE_TYPE, err.Error())
+			continue
+		}
+		if !ok {
+			if req == "required" {
+				errs.add(key, RULE_REQUIRED, "missing or empty")
+			}
+			continue
+		}
+		found = true
+		if rules := sf.Tag.Get("valid"); len(rules) > 0 {
+			validateField(v.Field(i), key, rules, errs)
+		}
+		if from == FROM_BODY {
+			validateStruct(v.Field(i), key+".", errs)
+		}
+	}
+	return found
+}
+
+func (r *Request) bindField(fv reflect.Value, from, key string, errs *ValidError) (bool, error) {
+	switch {
+	case from == FROM_BODY:
+		return r.bindJson(fv, key)
+	case from == FROM_FORM && (fv.Type() == fileHeaderType || fv.Type() == fileHeadersType):
+		return r.bindFile(fv, key)
+	case isStruct(fv.Type()):
+		//嵌套结构体的参数名为：父字段名.子字段名
+		return r.bindNested(fv, from, key+".", errs), nil
+	}
+
+	values := r.values(from, key)
+	if len(values) == 0 {
+		return false, nil
+	}
+	//只记录query参数，header、form中常有token、密码等
+	if from == FROM_QUERY {
+		r.PushNotice(key, strings.Join(values, ","))
+	}
+	return true, setValues(fv, values)
+}
+
+func (r *Request) bindNested(fv reflect.Value, from, prefix string, errs *ValidError) bool {
+	if fv.Kind() != reflect.Ptr {
+		return r.bind(fv, prefix, errs)
+	}
+	elem := reflect.New(fv.Type().Elem())
+	found := r.bind(elem.Elem(), prefix, errs)
+	if found {
+		fv.Set(elem)
+	}
+	return found
+}
+
+//非空的字符串参数
+func (r *Request) values(from, key string) []string {
+	var values []string
+	switch from {
+	case FROM_QUERY:
+		values = r.QueryArray(key)
+	case FROM_FORM:
+		values = r.PostFormArray(key)
+	case FROM_PATH:
+		values = []string{r.Param(key)}
+	case FROM_HEADER:
+		values = r.Request.Header[http.CanonicalHeaderKey(key)]
+	}
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = utils.Trim(value); len(value) > 0 {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+//json body按顶层字段名解析到字段，null视为未传
+func (r *Request) bindJson(fv reflect.Value, key string) (bool, error) {
+	if r.body == nil {
+		body, err := r.rawBody()
+		if err != nil {
+			return false, err
+		}
+		r.body = make(map[string]json.RawMessage)
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if err := json.Unmarshal(body, &r.body); err != nil {
+				return false, err
+			}
+		}
+	}
+	raw, ok := r.body[key]
+	if !ok || string(raw) == "null" {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, fv.Addr().Interface())
+}
+
+//读取后缓存在gin.BodyBytesKey，与gin的ShouldBindBodyWith共用
+func (r *Request) rawBody() ([]byte, error) {
+	if cached, ok := r.Get(gin.BodyBytesKey); ok {
+		if body, ok := cached.([]byte); ok {
+			return body, nil
+		}
+	}
+	body, err := r.GetRawData()
+	if err != nil {
+		return nil, err
+	}
+	r.Set(gin.BodyBytesKey, body)
+	return body, nil
+}
+
+func (r *Request) bindFile(fv reflect.Value, key string) (bool, error) {
+	form, err := r.MultipartForm()
+	if err != nil || form == nil || len(form.File[key]) == 0 {
+		return false, nil
+	}
+	if fv.Type() == fileHeaderType {
+		fv.Set(reflect.ValueOf(form.File[key][0]))
+	} else {
+		fv.Set(reflect.ValueOf(form.File[key]))
+	}
+	return true, nil
+}
+
+func isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+//slice支持重复参数(ids=1&ids=2)或逗号分隔(ids=1,2)，指针字段在有值时分配
+func setValues(fv reflect.Value, values []string) error {
+	switch {
+	case fv.Kind() == reflect.Ptr:
+		elem := reflect.New(fv.Type().Elem())
+		if err := setValues(elem.Elem(), values); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8:
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValues(slice.Index(i), []string{utils.Trim(value)}); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	return setString(fv, values[0])
+}
+
+func setString(fv reflect.Value, s string) error {
+	if fv.Type() == timeType {
+		t, err := parseTime(s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		if s == "on" {
+			s = "true"
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		fv.SetBytes([]byte(s))
+	default:
+		return errors.New("unsupported type " + fv.Type().String())
+	}
+	return nil
+}
+
+//支持RFC3339、"2006-01-02 15:04:05"、"2006-01-02"及unix秒
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, errors.New("invalid time " + s)
+}
@@ -1,74 +1,35 @@
 package request
 
 import (
-	"fmt"
+	"encoding/json"
 	"reflect"
-	"strconv"
-	"strings"
 
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
 	"github.com/neil-peng/gomvc/utils"
 )
 
 type Request struct {
 	*utils.Context
+	body map[string]json.RawMessage
 }
 
+//按字段tag绑定并校验请求参数，例：
+//UserId int `req:"required" from:"body" name:"user_id" valid:"min=1"`
+//req: required|optional；from: 参数来源，见FROM_*，默认query；name: 参数名，默认小写的字段名；
+//valid: 校验规则，见validate.go；
+//全部字段处理完后返回*ValidError，包含每个字段的错误
 func (r *Request) Valid(req interface{}) error {
-	reqElem := reflect.ValueOf(req).Elem()
-	reqType := reqElem.Type()
-	for i := 0; i < reqElem.NumField(); i++ {
-		if len(reqType.Field(i).Tag.Get("req")) == 0 {
-			continue
-		}
-
-		keyName := strings.ToLower(reqType.Field(i).Name)
-		requiredKeyValue := utils.Trim(r.Query(keyName))
-		if len(requiredKeyValue) == 0 {
-			if reqType.Field(i).Tag.Get("req") == "required" {
-				return fmt.Errorf("missing or emtpy required param %s", keyName)
-			} else {
-				continue
-			}
-		}
-		r.PushNotice(keyName, requiredKeyValue)
-		fv := reqElem.Field(i)
-		switch fv.Type().Kind() {
-		case reflect.Int:
-			intValue, err := strconv.ParseInt(requiredKeyValue, 10, 0)
-			if err != nil {
-				return err
-			}
-			fv.SetInt(intValue)
-		case reflect.Int64:
-			int64Value, err := strconv.ParseInt(requiredKeyValue, 10, 64)
-			if err != nil {
-				return err
-			}
-			fv.SetInt(int64Value)
-		case reflect.Uint:
-			uintValue, err := strconv.ParseUint(requiredKeyValue, 10, 0)
-			if err != nil {
-				return err
-			}
-			fv.SetUint(uintValue)
-		case reflect.Uint64:
-			uint64Value, err := strconv.ParseUint(requiredKeyValue, 10, 64)
-			if err != nil {
-				return err
-			}
-			fv.SetUint(uint64Value)
-		case reflect.Float32, reflect.Float64:
-			floatValue, err := strconv.ParseInt(requiredKeyValue, 10, 64)
-			if err != nil {
-				return err
-			}
-			fv.SetFloat(float64(floatValue))
-		case reflect.String:
-			fv.SetString(requiredKeyValue)
-		default:
-			panic(fmt.Errorf("%v", conf.ERROR_PARAM_ERROR))
-		}
+	reqValue := reflect.ValueOf(req)
+	if reqValue.Kind() != reflect.Ptr || reqValue.Elem().Kind() != reflect.Struct {
+		r.Critical("valid request with non struct pointer %T", req)
+		return apperr.New(conf.ERROR_PARAM_ERROR)
+	}
+	errs := &ValidError{}
+	r.bind(reqValue.Elem(), "", errs)
+	if len(errs.Fields) > 0 {
+		r.Warn("valid request fail, err:%s", errs.Detail())
+		return errs
 	}
 	return nil
 }
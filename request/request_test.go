@@ -0,0 +1,213 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/neil-peng/gomvc/utils"
+	"gotest.tools/assert"
+)
+
+func newTestRequest(method, target, contentType string, body []byte) *Request {
+	gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+	gc.Request = httptest.NewRequest(method, target, bytes.NewReader(body))
+	if len(contentType) > 0 {
+		gc.Request.Header.Set("Content-Type", contentType)
+	}
+	return &Request{Context: &utils.Context{Context: gc, Logger: utils.NewLogger()}}
+}
+
+type page struct {
+	Size int `req:"optional"`
+	No   int `req:"optional"`
+}
+
+type profile struct {
+	Nick string   `json:"nick"`
+	Tags []string `json:"tags"`
+}
+
+type bindReq struct {
+	Key     string     `req:"required"`
+	Ids     []int64    `req:"optional"`
+	Enabled bool       `req:"optional"`
+	Since   time.Time  `req:"optional"`
+	Limit   *int       `req:"optional"`
+	Offset  *int       `req:"optional"`
+	Page    page       `req:"optional"`
+	UserId  int        `req:"required" from:"body" name:"user_id"`
+	Profile *profile   `req:"optional" from:"body"`
+	Trace   string     `req:"optional" from:"header" name:"X-Trace"`
+	Id      uint       `req:"required" from:"path"`
+	Score   float64    `req:"optional"`
+	Missing *time.Time `req:"optional" from:"body"`
+}
+
+func TestValidBind(t *testing.T) {
+	r := newTestRequest("POST",
+		"/item/9?key=k&ids=1&ids=2&enabled=true&since=2020-01-02&limit=5&page.size=20&score=1.5",
+		"application/json", []byte(`{"user_id":7,"profile":{"nick":"n","tags":["a","b"]},"missing":null}`))
+	r.Request.Header.Set("X-Trace", "abc")
+	r.Params = gin.Params{{Key: "id", Value: "9"}}
+
+	req := &bindReq{}
+	assert.NilError(t, r.Valid(req))
+	assert.Equal(t, req.Key, "k")
+	assert.DeepEqual(t, req.Ids, []int64{1, 2})
+	assert.Equal(t, req.Enabled, true)
+	assert.Equal(t, req.Since.Format("2006-01-02"), "2020-01-02")
+	assert.Equal(t, *req.Limit, 5)
+	assert.Equal(t, req.Offset == nil, true)
+	assert.Equal(t, req.Page.Size, 20)
+	assert.Equal(t, req.UserId, 7)
+	assert.DeepEqual(t, *req.Profile, profile{Nick: "n", Tags: []string{"a", "b"}})
+	assert.Equal(t, req.Trace, "abc")
+	assert.Equal(t, req.Id, uint(9))
+	assert.Equal(t, req.Score, 1.5)
+	assert.Equal(t, req.Missing == nil, true)
+}
+
+func TestValidRequired(t *testing.T) {
+	r := newTestRequest("GET", "/?key=", "", nil)
+	assert.ErrorContains(t, r.Valid(&struct {
+		Key string `req:"required"`
+	}{}), "10006")
+
+	r = newTestRequest("GET", "/?count=x", "", nil)
+	assert.ErrorContains(t, r.Valid(&struct {
+		Count int `req:"optional"`
+	}{}), "10006")
+}
+
+func TestValidForm(t *testing.T) {
+	r := newTestRequest("POST", "/", "application/x-www-form-urlencoded", []byte("name=a&ids=1,2"))
+	req := &struct {
+		Name string `req:"required" from:"form"`
+		Ids  []int  `req:"required" from:"form"`
+	}{}
+	assert.NilError(t, r.Valid(req))
+	assert.Equal(t, req.Name, "a")
+	assert.DeepEqual(t, req.Ids, []int{1, 2})
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("name", "b")
+	fw, _ := w.CreateFormFile("file", "a.txt")
+	fw.Write([]byte("content"))
+	w.Close()
+	r = newTestRequest("POST", "/", w.FormDataContentType(), body.Bytes())
+	upload := &struct {
+		Name string                `req:"required" from:"form"`
+		File *multipart.FileHeader `req:"required" from:"form"`
+	}{}
+	assert.NilError(t, r.Valid(upload))
+	assert.Equal(t, upload.Name, "b")
+	assert.Equal(t, upload.File.Filename, "a.txt")
+}
+
+type address struct {
+	City string `json:"city" valid:"min=2"`
+}
+
+type validReq struct {
+	Name    string   `req:"required" valid:"min=2,max=4"`
+	Age     int      `req:"optional" valid:"min=18"`
+	Code    string   `req:"optional" valid:"len=3,regex=^[a-z]{1,3}$"`
+	Color   string   `req:"optional" valid:"enum=red|blue"`
+	Email   string   `req:"optional" valid:"email"`
+	Site    string   `req:"optional" valid:"url"`
+	Even    int      `req:"optional" valid:"even"`
+	Tags    []string `req:"optional" valid:"max=2"`
+	Token   string   `req:"required"`
+	Address *address `req:"optional" from:"body"`
+}
+
+func TestValidRules(t *testing.T) {
+	RegisterValidator("even", func(value interface{}, param string) error {
+		if value.(int)%2 != 0 {
+			return fmt.Errorf("must be even")
+		}
+		return nil
+	})
+
+	r := newTestRequest("POST",
+		"/?name=abcde&age=3&code=ab1&color=green&email=a@&site=nourl&even=3&tags=a,b,c",
+		"application/json", []byte(`{"address":{"city":"x"}}`))
+	err := r.Valid(&validReq{})
+	assert.ErrorContains(t, err, conf.ERROR_PARAM_ERROR)
+	verr, ok := err.(*ValidError)
+	assert.Equal(t, ok, true)
+
+	var got []string
+	for _, f := range verr.Fields {
+		got = append(got, f.Field+":"+f.Rule)
+	}
+	assert.DeepEqual(t, got, []string{
+		"name:max", "age:min", "code:regex", "color:enum", "email:email", "site:url",
+		"even:even", "tags:max", "token:required", "address.city:min",
+	})
+
+	r = newTestRequest("GET", "/?name=abc&age=20&code=abc&color=red&email=a@b.com&site=http://a.com&even=2&token=t", "", nil)
+	assert.NilError(t, r.Valid(&validReq{}))
+}
+
+type accessSink struct {
+	lines []string
+}
+
+func (s *accessSink) Write(level int32, p []byte) error {
+	s.lines = append(s.lines, string(p))
+	return nil
+}
+
+func (s *accessSink) Reopen() error { return nil }
+
+func (s *accessSink) Close() error { return nil }
+
+func TestValidNoticeQueryOnly(t *testing.T) {
+	access := &accessSink{}
+	utils.SetAccessLogSinks(access)
+	defer utils.SetAccessLogSinks()
+
+	r := newTestRequest("POST", "/?key=k", "application/x-www-form-urlencoded", []byte("password=secret"))
+	r.Request.Header.Set("Authorization", "Bearer token")
+	req := &struct {
+		Key      string `req:"required"`
+		Password string `req:"required" from:"form"`
+		Auth     string `req:"required" from:"header" name:"Authorization"`
+	}{}
+	assert.NilError(t, r.Valid(req))
+	r.Access("POST", "/", 200, "0", time.Millisecond)
+	assert.Equal(t, len(access.lines), 1)
+	assert.Assert(t, strings.Contains(access.lines[0], " key=k"))
+	assert.Assert(t, !strings.Contains(access.lines[0], "secret"))
+	assert.Assert(t, !strings.Contains(access.lines[0], "token"))
+}
+
+func TestValidSliceElems(t *testing.T) {
+	type sliceReq struct {
+		Colors []string `req:"optional" valid:"enum=red|green"`
+		Emails []string `req:"optional" valid:"email"`
+		Codes  []string `req:"optional" valid:"regex=^[a-z]+$"`
+		Sites  []string `req:"optional" valid:"url"`
+	}
+	r := newTestRequest("GET", "/?colors=red,green&emails=a@b.com,c@d.com&codes=ab,cd&sites=http://a.com,https://b.com", "", nil)
+	assert.NilError(t, r.Valid(&sliceReq{}))
+
+	r = newTestRequest("GET", "/?colors=red,blue&emails=a@b.com,c@&codes=ab,c1&sites=http://a.com,nourl", "", nil)
+	err := r.Valid(&sliceReq{})
+	verr, ok := err.(*ValidError)
+	assert.Equal(t, ok, true)
+	var got []string
+	for _, f := range verr.Fields {
+		got = append(got, f.Field+":"+f.Rule)
+	}
+	assert.DeepEqual(t, got, []string{"colors:enum", "emails:email", "codes:regex", "sites:url"})
+}
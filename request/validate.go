@@ -0,0 +1,287 @@
+package request
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//校验规则，tag：`valid:"min=1,max=100,enum=a|b|c,email"`，多条规则以逗号分隔，regex须放在最后
+//min/max: 数值的取值范围，字符串、slice、map的长度范围
+//len: 字符串、slice、map的长度
+//regex: 字符串须匹配的正则
+//enum: 可选值，以|分隔
+//email、url: 格式校验
+//regex、enum、email、url作用于slice时逐个元素校验
+//其他规则名为RegisterValidator注册的自定义校验
+const (
+	RULE_REQUIRED = "required"
+	RULE_TYPE     = "type"
+	RULE_MIN      = "min"
+	RULE_MAX      = "max"
+	RULE_LEN      = "len"
+	RULE_REGEX    = "regex"
+	RULE_ENUM     = "enum"
+	RULE_EMAIL    = "email"
+	RULE_URL      = "url"
+)
+
+//自定义校验，value为字段值(指针已解引用)，param为规则中=之后的部分，校验失败返回错误说明
+type ValidatorFunc func(value interface{}, param string) error
+
+var (
+	validators   = map[string]ValidatorFunc{}
+	validatorsMu sync.RWMutex
+	regexCache   sync.Map //string -> *regexp.Regexp
+)
+
+//注册自定义校验规则，通常在init中调用
+func RegisterValidator(name string, fn ValidatorFunc) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators[name] = fn
+}
+
+//单个字段的错误
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Msg   string `json:"msg"`
+}
+
+//参数错误，Error()为conf.ERROR_PARAM_ERROR，Fields为各字段的错误
+type ValidError struct {
+	Fields []FieldError
+}
+
+func (e *ValidError) Error() string {
+	return conf.ERROR_PARAM_ERROR
+}
+
+//返回给调用方的字段错误列表
+func (e *ValidError) Details() interface{} {
+	return e.Fields
+}
+
+func (e *ValidError) Detail() string {
+	items := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		items = append(items, f.Field+"("+f.Rule+"): "+f.Msg)
+	}
+	return strings.Join(items, "; ")
+}
+
+//错误信息模板参数：field、rule、msg为第一个字段的错误，fields为全部出错的字段，以逗号分隔
+func (e *ValidError) Args() map[string]interface{} {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		names = append(names, f.Field)
+	}
+	return map[string]interface{}{
+		"field":  e.Fields[0].Field,
+		"rule":   e.Fields[0].Rule,
+		"msg":    e.Fields[0].Msg,
+		"fields": strings.Join(names, ","),
+	}
+}
+
+func (e *ValidError) add(field, rule, msg string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Rule: rule, Msg: msg})
+}
+
+func validateField(fv reflect.Value, key, rules string, errs *ValidError) {
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return
+		}
+		fv = fv.Elem()
+	}
+	for _, rule := range splitRules(rules) {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		if msg := checkRule(fv, name, param); len(msg) > 0 {
+			errs.add(key, name, msg)
+		}
+	}
+}
+
+//结构体字段(如json body中的对象)按其字段的valid tag校验，字段名取json tag
+func validateStruct(fv reflect.Value, prefix string, errs *ValidError) {
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return
+		}
+		fv = fv.Elem()
+	}
+	if !isStruct(fv.Type()) {
+		return
+	}
+	t := fv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if len(sf.PkgPath) > 0 {
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if len(name) == 0 {
+			name = sf.Name
+		}
+		if rules := sf.Tag.Get("valid"); len(rules) > 0 {
+			validateField(fv.Field(i), prefix+name, rules, errs)
+		}
+		validateStruct(fv.Field(i), prefix+name+".", errs)
+	}
+}
+
+//regex之后的内容全部作为正则
+func splitRules(rules string) []string {
+	var result []string
+	for len(rules) > 0 {
+		if strings.HasPrefix(rules, RULE_REGEX+"=") {
+			return append(result, rules)
+		}
+		i := strings.Index(rules, ",")
+		if i < 0 {
+			return append(result, rules)
+		}
+		if i > 0 {
+			result = append(result, rules[:i])
+		}
+		rules = rules[i+1:]
+	}
+	return result
+}
+
+//返回空串表示通过
+func checkRule(fv reflect.Value, name, param string) string {
+	switch name {
+	case RULE_MIN, RULE_MAX, RULE_LEN:
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "invalid rule param " + param
+		}
+		value, isLen, ok := measure(fv)
+		if !ok || (name == RULE_LEN && !isLen) {
+			return "rule not applicable to " + fv.Type().String()
+		}
+		what := "value"
+		if isLen {
+			what = "length"
+		}
+		switch {
+		case name == RULE_MIN && value < limit:
+			return fmt.Sprintf("%s must be at least %s", what, param)
+		case name == RULE_MAX && value > limit:
+			return fmt.Sprintf("%s must be at most %s", what, param)
+		case name == RULE_LEN && value != limit:
+			return fmt.Sprintf("length must be %s", param)
+		}
+	case RULE_REGEX:
+		re, err := compileRegex(param)
+		if err != nil {
+			return "invalid regex " + param
+		}
+		if !eachValue(fv, re.MatchString) {
+			return "must match " + param
+		}
+	case RULE_ENUM:
+		items := strings.Split(param, "|")
+		if !eachValue(fv, func(s string) bool {
+			for _, item := range items {
+				if item == s {
+					return true
+				}
+			}
+			return false
+		}) {
+			return "must be one of " + param
+		}
+	case RULE_EMAIL:
+		if !eachValue(fv, func(s string) bool {
+			addr, err := mail.ParseAddress(s)
+			return err == nil && addr.Address == s
+		}) {
+			return "must be a valid email"
+		}
+	case RULE_URL:
+		if !eachValue(fv, func(s string) bool {
+			u, err := url.ParseRequestURI(s)
+			return err == nil && len(u.Scheme) > 0 && len(u.Host) > 0
+		}) {
+			return "must be a valid url"
+		}
+	default:
+		validatorsMu.RLock()
+		fn, ok := validators[name]
+		validatorsMu.RUnlock()
+		if !ok {
+			return "unknown rule"
+		}
+		if err := fn(fv.Interface(), param); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+//slice、array逐个元素校验，其余按值的字符串形式校验；nil指针元素跳过
+func eachValue(fv reflect.Value, check func(s string) bool) bool {
+	if fv.Kind() != reflect.Slice && fv.Kind() != reflect.Array {
+		return check(fmt.Sprint(fv.Interface()))
+	}
+	for i := 0; i < fv.Len(); i++ {
+		elem := fv.Index(i)
+		for elem.Kind() == reflect.Ptr && !elem.IsNil() {
+			elem = elem.Elem()
+		}
+		if elem.Kind() == reflect.Ptr {
+			continue
+		}
+		if !check(fmt.Sprint(elem.Interface())) {
+			return false
+		}
+	}
+	return true
+}
+
+//数值返回其值，字符串、slice、map返回长度
+func measure(fv reflect.Value) (value float64, isLen bool, ok bool) {
+	switch fv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(fv.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), false, true
+	}
+	return 0, false, false
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(expr, re)
+	return re, nil
+}
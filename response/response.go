@@ -4,6 +4,8 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/neil-peng/gomvc/apperr"
+	"github.com/neil-peng/gomvc/conf"
 	"github.com/neil-peng/gomvc/utils"
 )
 
@@ -11,15 +13,69 @@ type Response struct {
 	*utils.Context
 }
 
+//按字段输出响应，字段名取json tag，无tag时为小写的字段名；
+//json tag为"-"的字段不输出，omitempty的零值字段不输出；匿名嵌入的结构体字段展开到同一层；
+//嵌套的结构体、slice、map原样交给编码器，按其json tag编码
 func (r *Response) Format(res interface{}) error {
 	if res == nil {
 		return nil
 	}
-	reqElem := reflect.ValueOf(res).Elem()
-	reqType := reqElem.Type()
-	for i := 0; i < reqElem.NumField(); i++ {
-		fieldName := strings.ToLower(reqType.Field(i).Name)
-		r.SetResponseBody(fieldName, reqElem.Field(i).Interface())
+	resValue := reflect.Indirect(reflect.ValueOf(res))
+	if resValue.Kind() != reflect.Struct {
+		r.Critical("format response with non struct %T", res)
+		return apperr.New(conf.ERROR_INNER_ERROR)
 	}
+	r.SetResponseMessage(res)
+	r.format(resValue)
 	return nil
 }
+
+func (r *Response) format(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		if sf.Anonymous && len(name) == 0 && isStruct(sf.Type) {
+			if fv.Kind() == reflect.Ptr && fv.IsNil() {
+				continue
+			}
+			r.format(reflect.Indirect(fv))
+			continue
+		}
+		if len(sf.PkgPath) > 0 {
+			continue
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && isEmpty(fv) {
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(sf.Name)
+		}
+		r.SetResponseBody(name, fv.Interface())
+	}
+}
+
+func isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neil-peng/gomvc/apperr"
+	"github.com/neil-peng/gomvc/conf"
+)
+
+const (
+	BALANCE_ROUNDROBIN = "roundrobin"
+	BALANCE_WEIGHTED   = "weighted"
+	BALANCE_LEASTCONN  = "leastconn"
+
+	defaultMaxFails      = 3
+	defaultEjectDuration = 10 * time.Second
+)
+
+//调用方上报GetServer所选实例的调用结果，连续失败的实例被暂时摘除
+type ResultReporter interface {
+	ReportResult(addr string, err error, latency time.Duration)
+}
+
+//ns支持时上报调用结果
+func ReportResult(ns NameService, addr string, err error, latency time.Duration) {
+	if reporter, ok := ns.(ResultReporter); ok {
+		reporter.ReportResult(addr, err, latency)
+	}
+}
+
+//只结束GetServer计入的进行中请求，不计成功或失败，例：取到实例后未发出请求
+type Releaser interface {
+	Release(addr string)
+}
+
+func ReleaseServer(ns NameService, addr string) {
+	if releaser, ok := ns.(Releaser); ok {
+		releaser.Release(addr)
+	}
+}
+
+//上报连接池中长连接上的调用结果，不改变进行中请求数
+type ResultRecorder interface {
+	RecordResult(addr string, err error, latency time.Duration)
+}
+
+//包装到addr的连接，读写的网络错误记为addr的失败，
+//使接受连接但无法处理命令的实例也被摘除；对端正常关闭(EOF)和本端关闭不计
+func ReportConn(ns NameService, addr string, conn net.Conn) net.Conn {
+	recorder, ok := ns.(ResultRecorder)
+	if !ok {
+		return conn
+	}
+	return &reportConn{Conn: conn, addr: addr, recorder: recorder}
+}
+
+type reportConn struct {
+	net.Conn
+	addr     string
+	recorder ResultRecorder
+}
+
+func (c *reportConn) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Read(b)
+	c.report(err, start)
+	return n, err
+}
+
+func (c *reportConn) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := c.Conn.Write(b)
+	c.report(err, start)
+	return n, err
+}
+
+func (c *reportConn) report(err error, start time.Time) {
+	var netErr net.Error
+	if err == nil || errors.Is(err, net.ErrClosed) || !errors.As(err, &netErr) {
+		return
+	}
+	c.recorder.RecordResult(c.addr, err, time.Since(start))
+}
+
+type instanceStat struct {
+	active     int64
+	fails      int
+	ejectUntil time.Time
+}
+
+//包装任意NameService的客户端负载均衡：
+//内层为Registry时在其实例中按策略选择并摘除异常实例，否则直接透传内层结果；
+//每次GetServer之后须调用ReportResult或Release，leastconn据此统计进行中的请求数
+type Balancer struct {
+	ns            NameService
+	policy        string
+	MaxFails      int           //连续失败次数达到后摘除
+	EjectDuration time.Duration //摘除时长，到期后重新加入
+
+	next  uint64
+	stats map[string]*instanceStat
+	mu    sync.Mutex
+}
+
+func NewBalancer(ns NameService, policy string) *Balancer {
+	return &Balancer{
+		ns:            ns,
+		policy:        policy,
+		MaxFails:      defaultMaxFails,
+		EjectDuration: defaultEjectDuration,
+		stats:         make(map[string]*instanceStat),
+	}
+}
+
+func (b *Balancer) GetServer(service string) (string, string, error) {
+	registry, ok := b.ns.(Registry)
+	if !ok {
+		ip, port, err := b.ns.GetServer(service)
+		if err == nil {
+			b.acquire(net.JoinHostPort(ip, port))
+		}
+		return ip, port, err
+	}
+	instances, err := registry.GetInstances(service)
+	if err != nil {
+		return "", "", err
+	}
+	ins := b.pick(instances)
+	if ins == nil {
+		return "", "", apperr.New(conf.ERROR_NAMESERVICE_ERROR)
+	}
+	return ins.Ip, ins.Port, nil
+}
+
+func (b *Balancer) GetInstances(service string) ([]*Instance, error) {
+	if registry, ok := b.ns.(Registry); ok {
+		return registry.GetInstances(service)
+	}
+	ip, port, err := b.ns.GetServer(service)
+	if err != nil {
+		return nil, err
+	}
+	return []*Instance{{Ip: ip, Port: port}}, nil
+}
+
+//内层不支持监听时不会回调
+func (b *Balancer) Watch(service string, cb WatchFunc) func() {
+	if registry, ok := b.ns.(Registry); ok {
+		return registry.Watch(service, cb)
+	}
+	return func() {}
+}
+
+//结束进行中的请求并记录结果
+func (b *Balancer) ReportResult(addr string, err error, latency time.Duration) {
+	b.Release(addr)
+	b.RecordResult(addr, err, latency)
+}
+
+func (b *Balancer) Release(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if stat := b.stat(addr); stat.active > 0 {
+		stat.active--
+	}
+}
+
+//err为nil时清零连续失败数，连续失败MaxFails次后摘除
+func (b *Balancer) RecordResult(addr string, err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stat := b.stat(addr)
+	if err == nil {
+		stat.fails = 0
+		return
+	}
+	stat.fails++
+	if stat.fails >= b.MaxFails {
+		stat.fails = 0
+		stat.ejectUntil = time.Now().Add(b.EjectDuration)
+		Warn("eject instance %s for %v, latency:%v, err:%v", addr, b.EjectDuration, latency, err)
+	}
+}
+
+func (b *Balancer) stat(addr string) *instanceStat {
+	stat, ok := b.stats[addr]
+	if !ok {
+		stat = &instanceStat{}
+		b.stats[addr] = stat
+	}
+	return stat
+}
+
+func (b *Balancer) acquire(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stat(addr).active++
+}
+
+//在未摘除的实例中选择，全部被摘除时在全部实例中选择，避免完全不可用
+func (b *Balancer) pick(instances []*Instance) *Instance {
+	if len(instances) == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	available := make([]*Instance, 0, len(instances))
+	for _, ins := range instances {
+		if now.After(b.stat(ins.Addr()).ejectUntil) {
+			available = append(available, ins)
+		}
+	}
+	if len(available) == 0 {
+		available = instances
+	}
+
+	var ins *Instance
+	switch b.policy {
+	case BALANCE_ROUNDROBIN:
+		ins = available[atomic.AddUint64(&b.next, 1)%uint64(len(available))]
+	case BALANCE_LEASTCONN:
+		var least int64 = -1
+		for _, candidate := range available {
+			active := b.stat(candidate.Addr()).active
+			//进行中请求数相同的实例随机选择
+			if least < 0 || active < least || (active == least && rand.Intn(2) == 0) {
+				ins, least = candidate, active
+			}
+		}
+	default:
+		ins = PickInstance(available)
+	}
+	b.stat(ins.Addr()).active++
+	return ins
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestBalancerEject(t *testing.T) {
+	f := NewFakeRegistry()
+	f.Set("mvc-redis", &Instance{Ip: "127.0.0.1", Port: "6379"}, &Instance{Ip: "127.0.0.2", Port: "6379"})
+	b := NewBalancer(f, BALANCE_ROUNDROBIN)
+	b.MaxFails = 2
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		ip, port, err := b.GetServer("mvc-redis")
+		assert.NilError(t, err)
+		seen[ip+":"+port]++
+		b.ReportResult(ip+":"+port, nil, time.Millisecond)
+	}
+	assert.Equal(t, seen["127.0.0.1:6379"], 2)
+	assert.Equal(t, seen["127.0.0.2:6379"], 2)
+
+	//连续失败达到MaxFails后摘除
+	b.ReportResult("127.0.0.1:6379", errors.New("refused"), time.Millisecond)
+	b.ReportResult("127.0.0.1:6379", errors.New("refused"), time.Millisecond)
+	for i := 0; i < 4; i++ {
+		ip, _, err := b.GetServer("mvc-redis")
+		assert.NilError(t, err)
+		assert.Equal(t, ip, "127.0.0.2")
+		b.ReportResult(ip+":6379", nil, time.Millisecond)
+	}
+
+	//全部摘除时仍可选择
+	b.ReportResult("127.0.0.2:6379", errors.New("refused"), time.Millisecond)
+	b.ReportResult("127.0.0.2:6379", errors.New("refused"), time.Millisecond)
+	_, _, err := b.GetServer("mvc-redis")
+	assert.NilError(t, err)
+}
+
+func TestBalancerLeastConn(t *testing.T) {
+	f := NewFakeRegistry()
+	f.Set("mvc-rpc", &Instance{Ip: "127.0.0.1", Port: "80"}, &Instance{Ip: "127.0.0.2", Port: "80"})
+	b := NewBalancer(f, BALANCE_LEASTCONN)
+
+	ip1, _, _ := b.GetServer("mvc-rpc")
+	ip2, _, _ := b.GetServer("mvc-rpc")
+	assert.Equal(t, ip1 != ip2, true)
+
+	//释放后进行中请求最少的实例优先
+	b.ReportResult(ip1+":80", nil, time.Millisecond)
+	ip, _, _ := b.GetServer("mvc-rpc")
+	assert.Equal(t, ip, ip1)
+}
+
+func TestBalancerRelease(t *testing.T) {
+	f := NewFakeRegistry()
+	f.Set("mvc-rpc", &Instance{Ip: "127.0.0.1", Port: "80"}, &Instance{Ip: "127.0.0.2", Port: "80"})
+	b := NewBalancer(f, BALANCE_ROUNDROBIN)
+	b.MaxFails = 2
+
+	//Release不清零连续失败数
+	b.RecordResult("127.0.0.1:80", errors.New("timeout"), time.Millisecond)
+	ip, port, _ := b.GetServer("mvc-rpc")
+	ReleaseServer(b, ip+":"+port)
+	b.RecordResult("127.0.0.1:80", errors.New("timeout"), time.Millisecond)
+	for i := 0; i < 2; i++ {
+		ip, port, _ := b.GetServer("mvc-rpc")
+		assert.Equal(t, ip, "127.0.0.2")
+		ReleaseServer(b, ip+":"+port)
+	}
+	assert.Equal(t, b.stat("127.0.0.2:80").active, int64(0))
+}
+
+func TestReportConn(t *testing.T) {
+	f := NewFakeRegistry()
+	f.Set("mvc-redis", &Instance{Ip: "127.0.0.1", Port: "6379"}, &Instance{Ip: "127.0.0.2", Port: "6379"})
+	b := NewBalancer(f, BALANCE_ROUNDROBIN)
+	b.MaxFails = 2
+
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := ReportConn(b, "127.0.0.1:6379", client)
+	//读超时计为失败，连续MaxFails次后摘除
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	for i := 0; i < 2; i++ {
+		_, err := conn.Read(make([]byte, 1))
+		assert.Assert(t, err != nil)
+	}
+	for i := 0; i < 2; i++ {
+		ip, port, _ := b.GetServer("mvc-redis")
+		assert.Equal(t, ip, "127.0.0.2")
+		ReleaseServer(b, ip+":"+port)
+	}
+
+	//本端关闭不计
+	closed, _ := net.Pipe()
+	conn = ReportConn(b, "127.0.0.2:6379", closed)
+	conn.Close()
+	_, err := conn.Read(make([]byte, 1))
+	assert.Assert(t, err != nil)
+	assert.Equal(t, b.stat("127.0.0.2:6379").fails, 0)
+
+	//不支持上报的NameService不包装
+	assert.Equal(t, ReportConn(&IpServer, "127.0.0.1:6379", client), client)
+}
@@ -1,14 +1,16 @@
 package utils
 
 import (
-	"fmt"
+	"context"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-collections/collections/stack"
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
 )
 
@@ -19,17 +21,124 @@ type Context struct {
 	nameServer    NameService
 	callers       *stack.Stack
 	costOpenClose bool
+	transactions  []Transaction
+	stdCtx        context.Context
+	cancel        context.CancelFunc
+	span          *Span //当前span，见StartTrace
 	sync.RWMutex
 }
 
+//请求内开启的事务，请求结束时统一提交或回滚
+type Transaction interface {
+	//err为nil时提交，否则回滚；已结束的事务直接返回nil
+	End(err error) error
+}
+
 func (c *Context) SetNameService(servicer NameService) {
 	c.nameServer = servicer
 }
 
+func (c *Context) GetNameService() NameService {
+	return c.nameServer
+}
+
 func (c *Context) GetServer(service string) (ip string, port string, err error) {
 	return c.nameServer.GetServer(service)
 }
 
+//以http请求的context为父节点设置处理时限，客户端断开或超时后db、redis等后端调用被取消；
+//timeout<=0时只继承请求的取消
+func (c *Context) SetTimeout(timeout time.Duration) {
+	parent := context.Background()
+	if c.Context != nil && c.Request != nil {
+		parent = c.Request.Context()
+	}
+	if timeout > 0 {
+		c.stdCtx, c.cancel = context.WithTimeout(parent, timeout)
+	} else {
+		c.stdCtx, c.cancel = context.WithCancel(parent)
+	}
+}
+
+//请求级的context.Context，传给QueryContext/ExecContext等后端调用
+func (c *Context) StdContext() context.Context {
+	if c == nil || c.stdCtx == nil {
+		return context.Background()
+	}
+	return c.stdCtx
+}
+
+//请求已超时或被取消
+func (c *Context) Canceled() bool {
+	return c.StdContext().Err() != nil
+}
+
+func (c *Context) Cancel() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func (c *Context) AddTransaction(tx Transaction) {
+	c.Lock()
+	defer c.Unlock()
+	c.transactions = append(c.transactions, tx)
+}
+
+//结束请求内所有未结束的事务，按开启的逆序处理，返回第一个失败
+func (c *Context) EndTransactions(err error) error {
+	c.Lock()
+	txs := c.transactions
+	c.transactions = nil
+	c.Unlock()
+
+	var endErr error
+	for i := len(txs) - 1; i >= 0; i-- {
+		if e := txs[i].End(err); e != nil && endErr == nil {
+			endErr = e
+		}
+	}
+	return endErr
+}
+
+//路由path参数，如/user/:id中的id；参数不存在或类型不符时返回ERROR_PARAM_ERROR
+func (c *Context) ParamString(key string) (string, error) {
+	value := c.Param(key)
+	if len(value) == 0 {
+		return "", apperr.Errorf(conf.ERROR_PARAM_ERROR, "path param %s missing", key).WithArgs(map[string]interface{}{"field": key})
+	}
+	return value, nil
+}
+
+func (c *Context) ParamInt(key string) (int, error) {
+	value, err := c.ParamInt64(key)
+	return int(value), err
+}
+
+func (c *Context) ParamInt64(key string) (int64, error) {
+	value, err := c.ParamString(key)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, apperr.Wrap(err, conf.ERROR_PARAM_ERROR).WithArgs(map[string]interface{}{"field": key})
+	}
+	return i, nil
+}
+
+func (c *Context) ParamUint64(key string) (uint64, error) {
+	value, err := c.ParamString(key)
+	if err != nil {
+		return 0, err
+	}
+	u, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, apperr.Wrap(err, conf.ERROR_PARAM_ERROR).WithArgs(map[string]interface{}{"field": key})
+	}
+	return u, nil
+}
+
 func (c *Context) SetResponseBody(key string, value interface{}) {
 	c.Lock()
 	defer c.Unlock()
@@ -125,6 +234,13 @@ func (c *Context) IfCloseGather() bool {
 	return c.costOpenClose
 }
 
+//StatusStart记录的调用，开启trace时同时是当前span
+type caller struct {
+	name  string
+	start time.Time
+	span  *Span
+}
+
 func (c *Context) StatusStart() {
 	if c.IfCloseGather() {
 		return
@@ -145,8 +261,10 @@ func (c *Context) StatusStart() {
 		} else {
 			funcName = callFunc.Name()
 		}
-		c.callers.Push(map[string]time.Time{
-			funcName: startTime,
+		c.callers.Push(&caller{
+			name:  funcName,
+			start: startTime,
+			span:  c.pushSpan(funcName),
 		})
 	}
 }
@@ -160,11 +278,9 @@ func (c *Context) StatusEnd() int {
 		return 0
 	}
 
-	callFuncInfo := c.callers.Pop().(map[string]time.Time)
-	for funcName, startTime := range callFuncInfo {
-		cost := time.Since(startTime) / time.Millisecond
-		c.PushNotice(funcName, fmt.Sprintf("%dms", cost))
-		return int(cost)
-	}
-	return 0
+	callFuncInfo := c.callers.Pop().(*caller)
+	c.popSpan(callFuncInfo.span)
+	cost := time.Since(callFuncInfo.start)
+	c.PushSection(callFuncInfo.name, cost)
+	return int(cost / time.Millisecond)
 }
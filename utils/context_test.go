@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+type fakeTransaction struct {
+	endErr error
+	ended  []error
+}
+
+func (f *fakeTransaction) End(err error) error {
+	f.ended = append(f.ended, err)
+	return f.endErr
+}
+
+func TestContextTimeout(t *testing.T) {
+	c := &Context{Logger: NewLogger()}
+	assert.Equal(t, c.Canceled(), false)
+
+	c.SetTimeout(10 * time.Millisecond)
+	defer c.Cancel()
+	_, ok := c.StdContext().Deadline()
+	assert.Equal(t, ok, true)
+	<-c.StdContext().Done()
+	assert.Equal(t, c.Canceled(), true)
+}
+
+func TestEndTransactions(t *testing.T) {
+	c := &Context{Logger: NewLogger()}
+	ok := &fakeTransaction{}
+	bad := &fakeTransaction{endErr: errors.New("commit fail")}
+	c.AddTransaction(ok)
+	c.AddTransaction(bad)
+
+	cbErr := errors.New("cb fail")
+	assert.Equal(t, c.EndTransactions(cbErr), bad.endErr)
+	assert.Equal(t, ok.ended[0], cbErr)
+	assert.Equal(t, bad.ended[0], cbErr)
+
+	//已结束的事务不会被再次处理
+	assert.NilError(t, c.EndTransactions(nil))
+	assert.Equal(t, len(ok.ended), 1)
+}
@@ -0,0 +1,376 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/ugorji/go/codec"
+)
+
+//内置的响应编码器
+const (
+	ENCODER_JSON     = "json"
+	ENCODER_JSONP    = "jsonp"
+	ENCODER_XML      = "xml"
+	ENCODER_MSGPACK  = "msgpack"
+	ENCODER_PROTOBUF = "protobuf"
+)
+
+//jsonp回调函数名的query参数
+const JSONP_CALLBACK = "callback"
+
+//响应编码器，body为SetResponseBody设置的字段，原始响应结构体可通过GetResponseMessage获取
+type Encoder interface {
+	ContentType() string
+	Encode(c *Context, body map[string]interface{}) ([]byte, error)
+}
+
+type encoderEntry struct {
+	name  string
+	mimes []string
+	enc   Encoder
+}
+
+var (
+	encoders   []*encoderEntry
+	encodersMu sync.RWMutex
+)
+
+func init() {
+	RegisterEncoder(ENCODER_JSON, jsonEncoder{}, "application/json")
+	RegisterEncoder(ENCODER_JSONP, jsonpEncoder{}, "application/javascript", "text/javascript")
+	RegisterEncoder(ENCODER_XML, xmlEncoder{}, "application/xml", "text/xml")
+	RegisterEncoder(ENCODER_MSGPACK, msgpackEncoder{}, "application/x-msgpack", "application/msgpack")
+	RegisterEncoder(ENCODER_PROTOBUF, protobufEncoder{}, "application/x-protobuf", "application/protobuf")
+}
+
+//注册编码器，mimes为Accept协商时匹配的类型；同名编码器覆盖原有的
+func RegisterEncoder(name string, enc Encoder, mimes ...string) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	for _, e := range encoders {
+		if e.name == name {
+			e.enc, e.mimes = enc, mimes
+			return
+		}
+	}
+	encoders = append(encoders, &encoderEntry{name: name, mimes: mimes, enc: enc})
+}
+
+func GetEncoder(name string) Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	for _, e := range encoders {
+		if e.name == name {
+			return e.enc
+		}
+	}
+	return nil
+}
+
+//选择编码器：路由指定的(WithEncoder)优先，其次按Accept协商，都没有时用json
+func (c *Context) Encoder() Encoder {
+	if enc := GetEncoder(c.GetString(conf.API_ENCODER)); enc != nil {
+		return enc
+	}
+	encodersMu.RLock()
+	var offered []string
+	byMime := map[string]Encoder{}
+	for _, e := range encoders {
+		for _, mime := range e.mimes {
+			offered = append(offered, mime)
+			byMime[mime] = e.enc
+		}
+	}
+	encodersMu.RUnlock()
+
+	if accept := c.GetHeader("Accept"); len(accept) > 0 && len(offered) > 0 {
+		if enc, ok := byMime[negotiateMime(accept, offered)]; ok {
+			return enc
+		}
+	}
+	return GetEncoder(ENCODER_JSON)
+}
+
+type acceptRange struct {
+	mime string
+	q    float64
+}
+
+//解析Accept，q不合法的忽略，q=0的保留用于排除
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		mime := strings.ToLower(strings.TrimSpace(parts[0]))
+		if mime == "*" {
+			mime = "*/*"
+		}
+		if !strings.Contains(mime, "/") {
+			continue
+		}
+		r := acceptRange{mime: mime, q: 1}
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+			if err != nil || q < 0 || q > 1 {
+				r.q = -1
+			} else {
+				r.q = q
+			}
+		}
+		if r.q >= 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+//匹配程度：3完全一致，2为type/*，1为*/*，0不匹配
+func matchMime(rng, mime string) int {
+	switch {
+	case rng == mime:
+		return 3
+	case rng == "*/*":
+		return 1
+	case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mime, rng[:len(rng)-1]):
+		return 2
+	}
+	return 0
+}
+
+//按q值选择offered中的类型，q相同时精确匹配优先，其次按offered的顺序；
+//客户端q最高的类型都不支持且接受*/*时(如浏览器)返回空，由调用方使用默认的json
+func negotiateMime(accept string, offered []string) string {
+	ranges := parseAccept(accept)
+	var maxQ float64
+	wildcard := false
+	for _, r := range ranges {
+		if r.q > maxQ {
+			maxQ = r.q
+		}
+		if r.mime == "*/*" && r.q > 0 {
+			wildcard = true
+		}
+	}
+	best, bestQ, bestLevel := "", 0.0, 0
+	for _, mime := range offered {
+		//取最精确的匹配的q
+		q, level := 0.0, 0
+		for _, r := range ranges {
+			if l := matchMime(r.mime, strings.ToLower(mime)); l > level {
+				q, level = r.q, l
+			}
+		}
+		if q <= 0 || level <= 1 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && level > bestLevel) {
+			best, bestQ, bestLevel = mime, q, level
+		}
+	}
+	if len(best) > 0 && (bestQ >= maxQ || !wildcard) {
+		return best
+	}
+	return ""
+}
+
+//按协商的编码器输出响应，编码失败时退回json
+func (c *Context) WriteResponse(code int) {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	c.RLock()
+	body := c.GetStringMap(conf.RESULT_BODY_MAP)
+	c.RUnlock()
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+
+	enc := c.Encoder()
+	data, err := enc.Encode(c, body)
+	if err != nil {
+		c.Warn("encode response fail, content type:%s, err:%v", enc.ContentType(), err)
+		enc = GetEncoder(ENCODER_JSON)
+		if data, err = enc.Encode(c, body); err != nil {
+			c.Warn("json response body fail, err:%v", err)
+		}
+	}
+	//禁止浏览器猜测类型，例：jsonp退回的json不会被当作脚本执行
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(code, enc.ContentType(), data)
+}
+
+//保存原始响应结构体，供protobuf等按结构体整体编码的编码器使用
+func (c *Context) SetResponseMessage(msg interface{}) {
+	c.Set(conf.RESULT_MESSAGE, msg)
+}
+
+func (c *Context) GetResponseMessage() interface{} {
+	msg, _ := c.Get(conf.RESULT_MESSAGE)
+	return msg
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (jsonEncoder) Encode(c *Context, body map[string]interface{}) ([]byte, error) {
+	return JSONEncode(body)
+}
+
+var jsonpCallbackRe = regexp.MustCompile(`^[A-Za-z_$][\w$.]{0,63}$`)
+
+//无回调参数时输出普通json，回调不合法时返回错误，由WriteResponse退回json
+type jsonpEncoder struct{}
+
+func (jsonpEncoder) ContentType() string {
+	return "application/javascript; charset=utf-8"
+}
+
+func (jsonpEncoder) Encode(c *Context, body map[string]interface{}) ([]byte, error) {
+	data, err := JSONEncode(body)
+	if err != nil {
+		return nil, err
+	}
+	callback := c.Query(JSONP_CALLBACK)
+	if len(callback) == 0 {
+		return data, nil
+	}
+	//回调只允许标识符，避免注入脚本
+	if !jsonpCallbackRe.MatchString(callback) {
+		return nil, errors.New("invalid jsonp callback")
+	}
+	var buf bytes.Buffer
+	buf.WriteString(callback)
+	buf.WriteByte('(')
+	buf.Write(bytes.TrimRight(data, "\n"))
+	buf.WriteString(");")
+	return buf.Bytes(), nil
+}
+
+//根元素为<response>，字段按key排序输出
+type xmlEncoder struct{}
+
+func (xmlEncoder) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+func (xmlEncoder) Encode(c *Context, body map[string]interface{}) ([]byte, error) {
+	data, err := xml.Marshal(xmlMap(body))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+type xmlMap map[string]interface{}
+
+func (m xmlMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if start.Name.Local == "xmlMap" {
+		start.Name.Local = "response"
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := e.EncodeElement(toXmlValue(m[k]), xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+//转换为xml可编码的值：各层map转为xmlMap，切片逐个元素转换，结构体等先按json转为map；
+//切片编码为同名的多个元素
+func toXmlValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return val.Error()
+	case map[string]interface{}:
+		m := make(xmlMap, len(val))
+		for k, e := range val {
+			m[k] = toXmlValue(e)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, e := range val {
+			items[i] = toXmlValue(e)
+		}
+		return items
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v
+	}
+	data, err := JSONEncode(v)
+	if err != nil {
+		return v
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return v
+	}
+	return toXmlValue(generic)
+}
+
+//结构体字段名优先取codec tag，其次json tag
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) ContentType() string {
+	return "application/x-msgpack"
+}
+
+func (msgpackEncoder) Encode(c *Context, body map[string]interface{}) ([]byte, error) {
+	var data []byte
+	var mh codec.MsgpackHandle
+	mh.WriteExt = true
+	err := codec.NewEncoderBytes(&data, &mh).Encode(body)
+	return data, err
+}
+
+//只编码Format传入的proto.Message，错误码等字段不在body中，失败的请求退回json
+type protobufEncoder struct{}
+
+func (protobufEncoder) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufEncoder) Encode(c *Context, body map[string]interface{}) ([]byte, error) {
+	msg, ok := c.GetResponseMessage().(proto.Message)
+	if !ok {
+		return nil, errors.New("response is not proto.Message")
+	}
+	if code, _ := body[conf.ERR_CODE].(string); len(code) > 0 && code != "0" {
+		return nil, errors.New("error response " + code)
+	}
+	return proto.Marshal(msg)
+}
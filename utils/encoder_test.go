@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/conf"
+	"github.com/ugorji/go/codec"
+	"gotest.tools/assert"
+)
+
+func newEncoderContext(target, accept string) (*Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	gc, _ := gin.CreateTestContext(w)
+	gc.Request = httptest.NewRequest("GET", target, nil)
+	if len(accept) > 0 {
+		gc.Request.Header.Set("Accept", accept)
+	}
+	c := &Context{Context: gc, Logger: NewLogger()}
+	c.SetResponseBody(conf.ERR_CODE, "0")
+	c.SetResponseBody("items", []string{"a", "b"})
+	return c, w
+}
+
+func TestEncoderNegotiate(t *testing.T) {
+	c, w := newEncoderContext("/", "")
+	c.WriteResponse(0)
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json; charset=utf-8")
+	assert.Equal(t, w.Body.String(), `{"error_code":"0","items":["a","b"]}`)
+
+	c, w = newEncoderContext("/", "text/html, application/xml;q=0.9")
+	c.WriteResponse(400)
+	assert.Equal(t, w.Code, 400)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/xml; charset=utf-8")
+	assert.Assert(t, strings.HasSuffix(w.Body.String(), "<response><error_code>0</error_code><items>a</items><items>b</items></response>"))
+
+	c, w = newEncoderContext("/", "application/x-msgpack")
+	c.WriteResponse(200)
+	var body struct {
+		ErrCode string   `codec:"error_code"`
+		Items   []string `codec:"items"`
+	}
+	assert.NilError(t, codec.NewDecoderBytes(w.Body.Bytes(), &codec.MsgpackHandle{}).Decode(&body))
+	assert.Equal(t, body.ErrCode, "0")
+	assert.DeepEqual(t, body.Items, []string{"a", "b"})
+
+	//非proto.Message退回json
+	c, w = newEncoderContext("/", "application/x-protobuf")
+	c.SetResponseMessage(struct{}{})
+	c.WriteResponse(200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json; charset=utf-8")
+}
+
+func TestEncoderRoute(t *testing.T) {
+	c, w := newEncoderContext("/?callback=cb", "application/json")
+	c.Set(conf.API_ENCODER, ENCODER_JSONP)
+	c.WriteResponse(200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/javascript; charset=utf-8")
+	assert.Equal(t, w.Body.String(), `cb({"error_code":"0","items":["a","b"]});`)
+}
+
+func TestEncoderJsonpCallback(t *testing.T) {
+	c, w := newEncoderContext("/?callback=jQuery_1.cb$", "application/javascript")
+	c.WriteResponse(200)
+	assert.Assert(t, strings.HasPrefix(w.Body.String(), "jQuery_1.cb$("))
+	assert.Equal(t, w.Header().Get("X-Content-Type-Options"), "nosniff")
+
+	//不合法的回调退回json
+	c, w = newEncoderContext("/?callback=alert(document.domain)%3Bx", "application/javascript")
+	c.WriteResponse(200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json; charset=utf-8")
+	assert.Equal(t, w.Body.String(), `{"error_code":"0","items":["a","b"]}`)
+}
+
+func TestEncoderXmlNested(t *testing.T) {
+	c, w := newEncoderContext("/", "application/xml")
+	c.SetResponseBody("list", []interface{}{map[string]interface{}{"id": 1}})
+	c.SetResponseBody("user", struct {
+		Name  string            `json:"name"`
+		Extra map[string]string `json:"extra"`
+	}{"neil", map[string]string{"k": "v"}})
+	c.WriteResponse(200)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/xml; charset=utf-8")
+	assert.Assert(t, strings.HasSuffix(w.Body.String(), "<response><error_code>0</error_code><items>a</items><items>b</items>"+
+		"<list><id>1</id></list><user><extra><k>v</k></extra><name>neil</name></user></response>"), w.Body.String())
+}
+
+func TestNegotiateMime(t *testing.T) {
+	offered := []string{"application/json", "application/javascript", "text/javascript", "application/xml", "text/xml", "application/x-msgpack"}
+	for accept, want := range map[string]string{
+		//浏览器：q最高的text/html不支持，接受*/*时用json
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": "",
+		"text/html, application/xml;q=0.9":                                "application/xml",
+		"application/xml;q=0.5, application/x-msgpack":                    "application/x-msgpack",
+		"application/x-msgpack, */*;q=0.1":                                "application/x-msgpack",
+		"application/json-patch+json":                                     "",
+		"application/xml-dtd":                                             "",
+		"application/*":                                                   "application/json",
+		"*/*":                                                             "",
+		"application/xml;q=0, text/*":                                     "text/javascript",
+		"application/xml;q=abc":                                           "",
+		"APPLICATION/XML":                                                 "application/xml",
+	} {
+		assert.Equal(t, negotiateMime(accept, offered), want, accept)
+	}
+
+	for _, accept := range []string{"application/json-patch+json", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"} {
+		c, w := newEncoderContext("/", accept)
+		c.WriteResponse(200)
+		assert.Equal(t, w.Header().Get("Content-Type"), "application/json; charset=utf-8")
+	}
+}
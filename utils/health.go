@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//框架提供的探活路由：livez进程存活；healthz检查全部依赖；readyz检查全部依赖，退出中返回503
+const (
+	LIVEZ_PATH   = "/livez"
+	HEALTHZ_PATH = "/healthz"
+	READYZ_PATH  = "/readyz"
+)
+
+const (
+	HEALTH_OK   = "ok"
+	HEALTH_FAIL = "fail"
+)
+
+const defaultHealthCheckTimeoutMs = 1000
+
+//依赖检查，返回nil为正常；ctx在HEALTH_CHECK_TIMEOUT_MS后超时
+type HealthChecker func(ctx context.Context) error
+
+type healthCheck struct {
+	name  string
+	check HealthChecker
+}
+
+var (
+	healthChecks   []healthCheck
+	healthChecksMu sync.RWMutex
+)
+
+//注册依赖检查，同名的替换原有的；db、redis在创建连接池时注册为db:<cluster>(读库为db:<cluster>:<addr>)、redis:<service>
+func RegisterHealthCheck(name string, check HealthChecker) {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	for i := range healthChecks {
+		if healthChecks[i].name == name {
+			healthChecks[i].check = check
+			return
+		}
+	}
+	healthChecks = append(healthChecks, healthCheck{name: name, check: check})
+}
+
+func UnregisterHealthCheck(name string) {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	for i := range healthChecks {
+		if healthChecks[i].name == name {
+			healthChecks = append(healthChecks[:i], healthChecks[i+1:]...)
+			return
+		}
+	}
+}
+
+//单个依赖的检查结果
+type HealthResult struct {
+	Status string `json:"status"`
+	CostMs int64  `json:"cost_ms"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]HealthResult `json:"checks"`
+}
+
+//并发执行全部依赖检查
+func CheckHealth(ctx context.Context) *HealthReport {
+	healthChecksMu.RLock()
+	checks := append([]healthCheck{}, healthChecks...)
+	healthChecksMu.RUnlock()
+
+	timeoutMs := conf.ApiConf.HEALTH_CHECK_TIMEOUT_MS
+	if timeoutMs <= 0 {
+		timeoutMs = defaultHealthCheckTimeoutMs
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+
+	report := &HealthReport{
+		Status: HEALTH_OK,
+		Checks: make(map[string]HealthResult, len(checks)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c healthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := runHealthCheck(ctx, c.check)
+			result := HealthResult{Status: HEALTH_OK, CostMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status, result.Error = HEALTH_FAIL, err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = HEALTH_FAIL
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+//检查函数不响应ctx时按超时返回
+func runHealthCheck(ctx context.Context, check HealthChecker) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Router) addHealthRoutes() {
+	r.engine.GET(LIVEZ_PATH, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": HEALTH_OK})
+	})
+	r.engine.GET(HEALTHZ_PATH, func(c *gin.Context) {
+		writeHealth(c, CheckHealth(c.Request.Context()))
+	})
+	r.engine.GET(READYZ_PATH, func(c *gin.Context) {
+		if r.draining() {
+			c.JSON(http.StatusServiceUnavailable, &HealthReport{Status: HEALTH_FAIL, Checks: map[string]HealthResult{
+				"shutdown": {Status: HEALTH_FAIL, Error: "shutting down"},
+			}})
+			return
+		}
+		writeHealth(c, CheckHealth(c.Request.Context()))
+	})
+	for _, path := range []string{LIVEZ_PATH, HEALTHZ_PATH, READYZ_PATH} {
+		r.routes = append(r.routes, RouteInfo{Method: http.MethodGet, Path: path, Actor: "health"})
+	}
+}
+
+func writeHealth(c *gin.Context, report *HealthReport) {
+	status := http.StatusOK
+	if report.Status != HEALTH_OK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
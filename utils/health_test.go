@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestHealth(t *testing.T) {
+	var dbErr error
+	RegisterHealthCheck("db:test", func(ctx context.Context) error {
+		return dbErr
+	})
+	RegisterHealthCheck("hang", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	UnregisterHealthCheck("hang")
+	defer UnregisterHealthCheck("db:test")
+
+	r := NewRouter()
+	probe := func(path string) (int, *HealthReport) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		report := &HealthReport{}
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), report))
+		return w.Code, report
+	}
+
+	code, report := probe(HEALTHZ_PATH)
+	assert.Equal(t, code, 200)
+	assert.Equal(t, report.Checks["db:test"].Status, HEALTH_OK)
+	_, hang := report.Checks["hang"]
+	assert.Assert(t, !hang)
+
+	dbErr = errors.New("connection refused")
+	code, report = probe(READYZ_PATH)
+	assert.Equal(t, code, 503)
+	assert.Equal(t, report.Status, HEALTH_FAIL)
+	assert.Equal(t, report.Checks["db:test"].Error, "connection refused")
+
+	dbErr = nil
+	code, _ = probe(READYZ_PATH)
+	assert.Equal(t, code, 200)
+	r.Shutdown()
+	code, report = probe(READYZ_PATH)
+	assert.Equal(t, code, 503)
+	assert.Equal(t, report.Checks["shutdown"].Status, HEALTH_FAIL)
+	code, _ = probe(LIVEZ_PATH)
+	assert.Equal(t, code, 200)
+}
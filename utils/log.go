@@ -10,7 +10,6 @@ import (
 	"runtime"
 	"runtime/debug"
 	"sort"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -48,20 +47,25 @@ var (
 type LoggerBase struct {
 	level        int32
 	mu           sync.Mutex // ensures atomic writes; protects the following fields
-	out          *os.File   // destination for output
-	out_wf       *os.File   // destination for output
-	path         string     // log file path
+	sinks        []LogSink  // destination for output
+	path         string     // log file path of the first FileSink
 	buf          []byte     // for accumulating text to write
 	backupCount  int
 	microseconds bool
 	shortfile    bool
 	printCatal   bool
+	format       string    // LOG_FORMAT_TEXT, LOG_FORMAT_JSON or LOG_FORMAT_LOGFMT
+	queue        *logQueue // async mode when not nil
 }
 
 type Logger struct {
-	reqinfo    map[string]string
-	noticeinfo map[string]string
-	buf        string
+	reqinfo    map[string]interface{}
+	reqkeys    []string
+	noticeinfo map[string]interface{}
+	noticekeys []string
+	sections   []logField //StatusStart/StatusEnd的耗时，单位ms
+	buf        string     //text格式的base info
+	accessed   bool
 	mu         sync.Mutex
 }
 
@@ -69,19 +73,19 @@ type Logger struct {
  * global static var
  */
 var _log = &LoggerBase{
-	out:          os.Stderr,
-	out_wf:       os.Stderr,
+	sinks:        []LogSink{&StdoutSink{out: os.Stderr, errOut: os.Stderr}},
 	level:        LEVEL_NOTICE,
 	backupCount:  0,
 	microseconds: true,
 	shortfile:    true,
 	printCatal:   false,
+	format:       LOG_FORMAT_TEXT,
 }
 
 func NewLogger() *Logger {
 	return &Logger{
-		reqinfo:    make(map[string]string),
-		noticeinfo: make(map[string]string),
+		reqinfo:    make(map[string]interface{}),
+		noticeinfo: make(map[string]interface{}),
 		buf:        "",
 	}
 }
@@ -104,33 +108,43 @@ func SetPrintCatal() {
 	_log.printCatal = true
 }
 
+//只输出到path及path.wf，等同SetLogSinks(NewFileSink(path))
 func SetLogFile(path string) {
 	//Critical("set log file to %v", path)
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	sink, err := NewFileSink(path)
 	if err != nil {
 		Critical("error on SetLogFile: err: %s", err)
+		return
 	}
+	SetLogSinks(sink)
+}
 
-	_log.out = f
-	_log.path = path
+//重新打开全部sink，path参数保留兼容
+func ReOpen(path string) {
+	//异步模式下先写完已有日志，避免写入新文件
+	Flush()
 
-	f_wf, err := os.OpenFile(path+".wf", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
-	if err != nil {
-		Critical("error on SetLogFile: err: %s", err)
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+
+	for _, sink := range append(accessSinks(), _log.sinks...) {
+		if err := sink.Reopen(); err != nil {
+			fmt.Fprintf(os.Stderr, "reopen log sink fail: %s\n", err)
+		}
 	}
-	_log.out_wf = f_wf
 }
 
-func ReOpen(path string) {
-	if _log.path == "" {
-		return
-	}
+func fileSinks() []*FileSink {
 	_log.mu.Lock()
 	defer _log.mu.Unlock()
 
-	_log.out.Close()
-	_log.out_wf.Close()
-	SetLogFile(_log.path)
+	var files []*FileSink
+	for _, sink := range append(accessSinks(), _log.sinks...) {
+		if f, ok := unwrapSink(sink).(*FileSink); ok {
+			files = append(files, f)
+		}
+	}
+	return files
 }
 
 func timestr(period time.Duration) string {
@@ -194,20 +208,13 @@ func getFilesToDelete(path string, fileFilter *regexp.Regexp, backupCount int) [
 /*
  * enable rotate whit peirod
  * peirod can be: time.Minute, time.Hour, 24 * time.Hour
+ * size based rotation see SetLogRotateSize
  */
 func SetLogRotate(period time.Duration) {
 	if period != time.Minute && period != time.Hour && period != time.Hour*24 {
 		Critical("bad rotate peirod: %s", period)
 		return
 	}
-	var fileFilter *regexp.Regexp
-	if period == time.Minute { //Min
-		fileFilter = regexp.MustCompile(`^\d{4}\d{2}\d{2}\d{2}\d{2}$`)
-	} else if period == time.Hour { //Hour
-		fileFilter = regexp.MustCompile(`^\d{4}\d{2}\d{2}\d{2}$`)
-	} else { //Day
-		fileFilter = regexp.MustCompile(`^\d{4}\d{2}\d{2}$`)
-	}
 	ch := make(chan bool)
 
 	go func() {
@@ -224,19 +231,9 @@ func SetLogRotate(period time.Duration) {
 		for {
 			<-ch
 			t := timestr(period)
-			//log
-			filename := fmt.Sprintf("%s.%s", _log.path, t)
-			os.Rename(_log.path, filename)
-			for _, fileName := range getFilesToDelete(_log.path, fileFilter, _log.backupCount) {
-				os.Remove(fileName)
+			for _, f := range fileSinks() {
+				f.Rotate(t)
 			}
-			//log.wf
-			filename = fmt.Sprintf("%s.wf.%s", _log.path, t)
-			os.Rename(_log.path+".wf", filename)
-			for _, fileName := range getFilesToDelete(_log.path+".wf", fileFilter, _log.backupCount) {
-				os.Remove(fileName)
-			}
-			ReOpen(_log.path)
 		}
 	}()
 }
@@ -246,93 +243,119 @@ func (L *Logger) formatBaseInfo() {
 	defer L.mu.Unlock()
 
 	if L.buf == "" && len(L.reqinfo) > 0 {
-		for k, v := range L.reqinfo {
-			L.buf += "[" + k + ":" + v + "] "
+		for _, k := range L.reqkeys {
+			L.buf += "[" + k + ":" + fmt.Sprintf("%v", L.reqinfo[k]) + "] "
 		}
 	}
 }
 
+//text格式的notice字段及分段耗时
+func (L *Logger) formatNoticeInfo() string {
+	L.mu.Lock()
+	defer L.mu.Unlock()
+
+	var buf string
+	for _, k := range L.noticekeys {
+		buf += "[" + k + ":" + fmt.Sprintf("%v", L.noticeinfo[k]) + "] "
+	}
+	for _, section := range L.sections {
+		buf += "[" + section.key + ":" + fmt.Sprintf("%vms", section.value) + "] "
+	}
+	return buf
+}
+
 func (L *Logger) SetBaseInfo(key string, value interface{}) {
 	L.mu.Lock()
 	defer L.mu.Unlock()
 
-	L.reqinfo[key] = fmt.Sprintf("%v", value)
+	if _, ok := L.reqinfo[key]; !ok {
+		L.reqkeys = append(L.reqkeys, key)
+	}
+	L.reqinfo[key] = value
+	L.buf = ""
 }
 
+//同名key覆盖原值，保持首次push的顺序
 func (L *Logger) PushNotice(key string, value interface{}) {
 	L.mu.Lock()
 	defer L.mu.Unlock()
 
-	L.noticeinfo[key] = fmt.Sprintf("%v", value)
+	if _, ok := L.noticeinfo[key]; !ok {
+		L.noticekeys = append(L.noticekeys, key)
+	}
+	L.noticeinfo[key] = value
+}
+
+//记录一段耗时，由StatusEnd调用，输出在notice日志和访问日志中
+func (L *Logger) PushSection(name string, cost time.Duration) {
+	L.mu.Lock()
+	defer L.mu.Unlock()
+
+	L.sections = append(L.sections, logField{key: name, value: cost.Milliseconds()})
 }
 
 func Critical(format string, v ...interface{}) {
-	_log.output(LEVEL_CRITICAL, "", format, v...)
+	_log.output(LEVEL_CRITICAL, "", nil, format, v...)
 }
 
 func (L *Logger) Critical(format string, v ...interface{}) {
 	L.formatBaseInfo()
-	_log.output(LEVEL_CRITICAL, L.buf, format, v...)
+	_log.output(LEVEL_CRITICAL, L.buf, L.fields(false), format, v...)
 }
 
 func Warn(format string, v ...interface{}) {
-	_log.output(LEVEL_WARNING, "", format, v...)
+	_log.output(LEVEL_WARNING, "", nil, format, v...)
 }
 
 func (L *Logger) Warn(format string, v ...interface{}) {
 	L.formatBaseInfo()
-	_log.output(LEVEL_WARNING, L.buf, format, v...)
+	_log.output(LEVEL_WARNING, L.buf, L.fields(false), format, v...)
 }
 
 func Notice(format string, v ...interface{}) {
-	_log.output(LEVEL_NOTICE, "", format, v...)
+	_log.output(LEVEL_NOTICE, "", nil, format, v...)
 }
 
 func (L *Logger) Notice(format string, v ...interface{}) {
 	L.formatBaseInfo()
+	fields := L.fields(true)
+	baseinfo := L.buf + L.formatNoticeInfo()
 
-	L.mu.Lock()
-	defer L.mu.Unlock()
-
-	for k, v := range L.noticeinfo {
-		L.buf += "[" + k + ":" + v + "] "
-	}
-
-	_log.output(LEVEL_NOTICE, L.buf, format, v...)
+	_log.output(LEVEL_NOTICE, baseinfo, fields, format, v...)
 }
 
 func Info(format string, v ...interface{}) {
-	_log.output(LEVEL_INFO, "", format, v...)
+	_log.output(LEVEL_INFO, "", nil, format, v...)
 }
 
 func (L *Logger) Info(format string, v ...interface{}) {
 	L.formatBaseInfo()
-	_log.output(LEVEL_INFO, L.buf, format, v...)
+	_log.output(LEVEL_INFO, L.buf, L.fields(false), format, v...)
 }
 
 func Debug(format string, v ...interface{}) {
-	_log.output(LEVEL_DEBUG, "", format, v...)
+	_log.output(LEVEL_DEBUG, "", nil, format, v...)
 }
 
 func (L *Logger) Debug(format string, v ...interface{}) {
 	L.formatBaseInfo()
-	_log.output(LEVEL_DEBUG, L.buf, format, v...)
+	_log.output(LEVEL_DEBUG, L.buf, L.fields(false), format, v...)
 }
 
 func Verbose(format string, v ...interface{}) {
-	_log.output(LEVEL_VERBOSE, "", format, v...)
+	_log.output(LEVEL_VERBOSE, "", nil, format, v...)
 }
 
 func (L *Logger) Verbose(format string, v ...interface{}) {
 	L.formatBaseInfo()
-	_log.output(LEVEL_VERBOSE, L.buf, format, v...)
+	_log.output(LEVEL_VERBOSE, L.buf, L.fields(false), format, v...)
 }
 
 func Stacktrace(level int32, format string, v ...interface{}) {
 	if level > GetLogLevel() {
 		return
 	}
-	_log.output(level, "", format+" --- stack: \n%s", v, debug.Stack())
+	_log.output(level, "", nil, format+" --- stack: \n%s", v, debug.Stack())
 }
 
 func (L *Logger) Stacktrace(level int32, format string, v ...interface{}) {
@@ -340,7 +363,7 @@ func (L *Logger) Stacktrace(level int32, format string, v ...interface{}) {
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(level, L.buf, format+" --- stack: \n%s", v, debug.Stack())
+	_log.output(level, L.buf, L.fields(false), format+" --- stack: \n%s", v, debug.Stack())
 }
 
 /*
@@ -352,7 +375,7 @@ func Debug1(format string, a interface{}) {
 		return
 	}
 
-	_log.output(LEVEL_DEBUG, "", format, a)
+	_log.output(LEVEL_DEBUG, "", nil, format, a)
 }
 
 func (L *Logger) Debug1(format string, a interface{}) {
@@ -360,7 +383,7 @@ func (L *Logger) Debug1(format string, a interface{}) {
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_DEBUG, L.buf, format, a)
+	_log.output(LEVEL_DEBUG, L.buf, L.fields(false), format, a)
 }
 
 func Debug2(format string, a interface{}, b interface{}) {
@@ -368,7 +391,7 @@ func Debug2(format string, a interface{}, b interface{}) {
 		return
 	}
 
-	_log.output(LEVEL_DEBUG, "", format, a, b)
+	_log.output(LEVEL_DEBUG, "", nil, format, a, b)
 }
 
 func (L *Logger) Debug2(format string, a interface{}, b interface{}) {
@@ -376,7 +399,7 @@ func (L *Logger) Debug2(format string, a interface{}, b interface{}) {
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_DEBUG, L.buf, format, a, b)
+	_log.output(LEVEL_DEBUG, L.buf, L.fields(false), format, a, b)
 }
 
 func Debug3(format string, a interface{}, b interface{}, c interface{}) {
@@ -384,7 +407,7 @@ func Debug3(format string, a interface{}, b interface{}, c interface{}) {
 		return
 	}
 
-	_log.output(LEVEL_DEBUG, "", format, a, b, c)
+	_log.output(LEVEL_DEBUG, "", nil, format, a, b, c)
 }
 
 func (L *Logger) Debug3(format string, a interface{}, b interface{}, c interface{}) {
@@ -392,7 +415,7 @@ func (L *Logger) Debug3(format string, a interface{}, b interface{}, c interface
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_DEBUG, L.buf, format, a, b, c)
+	_log.output(LEVEL_DEBUG, L.buf, L.fields(false), format, a, b, c)
 }
 
 func Debug4(format string, a interface{}, b interface{}, c interface{}, d interface{}) {
@@ -400,7 +423,7 @@ func Debug4(format string, a interface{}, b interface{}, c interface{}, d interf
 		return
 	}
 
-	_log.output(LEVEL_DEBUG, "", format, a, b, c, d)
+	_log.output(LEVEL_DEBUG, "", nil, format, a, b, c, d)
 }
 
 func (L *Logger) Debug4(format string, a interface{}, b interface{}, c interface{}, d interface{}) {
@@ -408,7 +431,7 @@ func (L *Logger) Debug4(format string, a interface{}, b interface{}, c interface
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_DEBUG, L.buf, format, a, b, c, d)
+	_log.output(LEVEL_DEBUG, L.buf, L.fields(false), format, a, b, c, d)
 }
 
 func Info1(format string, a interface{}) {
@@ -416,7 +439,7 @@ func Info1(format string, a interface{}) {
 		return
 	}
 
-	_log.output(LEVEL_INFO, "", format, a)
+	_log.output(LEVEL_INFO, "", nil, format, a)
 }
 
 func (L *Logger) Info1(format string, a interface{}) {
@@ -424,7 +447,7 @@ func (L *Logger) Info1(format string, a interface{}) {
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_INFO, L.buf, format, a)
+	_log.output(LEVEL_INFO, L.buf, L.fields(false), format, a)
 }
 
 func Info2(format string, a interface{}, b interface{}) {
@@ -432,7 +455,7 @@ func Info2(format string, a interface{}, b interface{}) {
 		return
 	}
 
-	_log.output(LEVEL_INFO, "", format, a, b)
+	_log.output(LEVEL_INFO, "", nil, format, a, b)
 }
 
 func (L *Logger) Info2(format string, a interface{}, b interface{}) {
@@ -440,7 +463,7 @@ func (L *Logger) Info2(format string, a interface{}, b interface{}) {
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_INFO, L.buf, format, a, b)
+	_log.output(LEVEL_INFO, L.buf, L.fields(false), format, a, b)
 }
 
 func Info3(format string, a interface{}, b interface{}, c interface{}) {
@@ -448,7 +471,7 @@ func Info3(format string, a interface{}, b interface{}, c interface{}) {
 		return
 	}
 
-	_log.output(LEVEL_INFO, "", format, a, b, c)
+	_log.output(LEVEL_INFO, "", nil, format, a, b, c)
 }
 
 func (L *Logger) Info3(format string, a interface{}, b interface{}, c interface{}) {
@@ -456,7 +479,7 @@ func (L *Logger) Info3(format string, a interface{}, b interface{}, c interface{
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_INFO, L.buf, format, a, b, c)
+	_log.output(LEVEL_INFO, L.buf, L.fields(false), format, a, b, c)
 }
 
 func Info4(format string, a interface{}, b interface{}, c interface{}, d interface{}) {
@@ -464,7 +487,7 @@ func Info4(format string, a interface{}, b interface{}, c interface{}, d interfa
 		return
 	}
 
-	_log.output(LEVEL_INFO, "", format, a, b, c, d)
+	_log.output(LEVEL_INFO, "", nil, format, a, b, c, d)
 }
 
 func (L *Logger) Info4(format string, a interface{}, b interface{}, c interface{}, d interface{}) {
@@ -472,7 +495,7 @@ func (L *Logger) Info4(format string, a interface{}, b interface{}, c interface{
 		return
 	}
 	L.formatBaseInfo()
-	_log.output(LEVEL_INFO, L.buf, format, a, b, c, d)
+	_log.output(LEVEL_INFO, L.buf, L.fields(false), format, a, b, c, d)
 }
 
 // Cheap integer to fixed-width decimal ASCII.
@@ -524,21 +547,7 @@ func (l *LoggerBase) formatHeader(buf *[]byte, t time.Time,
 	*buf = append(*buf, levelStrings[level]...)
 	*buf = append(*buf, ' ')
 
-	short := file
-	if l.printCatal {
-		index := strings.LastIndex(file, "src")
-		file = file[index:]
-	} else {
-		for i := len(file) - 1; i > 0; i-- {
-			if file[i] == '/' {
-				short = file[i+1:]
-				break
-			}
-		}
-		file = short
-	}
-
-	*buf = append(*buf, file...)
+	*buf = append(*buf, l.callerFile(file)...)
 	*buf = append(*buf, ':')
 	itoa(buf, line, -1)
 	*buf = append(*buf, ": "...)
@@ -569,27 +578,13 @@ func (l *LoggerBase) formatHeaderBasic(buf *[]byte, t time.Time, file string, li
 	*buf = append(*buf, ' ')
 
 	// xxx.go (filename)
-	short := file
-	if l.printCatal {
-		index := strings.LastIndex(file, "src")
-		file = file[index:]
-	} else {
-		for i := len(file) - 1; i > 0; i-- {
-			if file[i] == '/' {
-				short = file[i+1:]
-				break
-			}
-		}
-		file = short
-	}
-
-	*buf = append(*buf, file...)
+	*buf = append(*buf, l.callerFile(file)...)
 	*buf = append(*buf, ':')
 	itoa(buf, line, -1)
 	*buf = append(*buf, ": "...)
 }
 
-func (l *LoggerBase) output(level int32, baseinfo, format string, v ...interface{}) error {
+func (l *LoggerBase) output(level int32, baseinfo string, fields []logField, format string, v ...interface{}) error {
 	if level > GetLogLevel() {
 		return nil
 	}
@@ -618,21 +613,21 @@ func (l *LoggerBase) output(level int32, baseinfo, format string, v ...interface
 	l.mu.Lock()
 
 	l.buf = l.buf[:0]
-	l.formatHeader(&l.buf, now, level, file, line)
-	l.buf = append(l.buf, baseinfo...)
-	l.buf = append(l.buf, s...)
-	if len(s) > 0 && s[len(s)-1] != '\n' {
-		l.buf = append(l.buf, '\n')
-	}
-
-	var err error
-	if level >= LEVEL_NOTICE {
-		_, err = l.out.Write(l.buf)
-	} else if level <= LEVEL_WARNING {
-		_, err = l.out_wf.Write(l.buf)
+	switch l.format {
+	case LOG_FORMAT_JSON:
+		l.formatJson(&l.buf, now, level, file, line, fields, s)
+	case LOG_FORMAT_LOGFMT:
+		l.formatLogfmt(&l.buf, now, level, file, line, fields, s)
+	default:
+		l.formatHeader(&l.buf, now, level, file, line)
+		l.buf = append(l.buf, baseinfo...)
+		l.buf = append(l.buf, s...)
+		if len(s) > 0 && s[len(s)-1] != '\n' {
+			l.buf = append(l.buf, '\n')
+		}
 	}
 
-	return err
+	return l.writeLocked(level)
 }
 
 func GetDefaultLogger() *LoggerBase {
@@ -663,7 +658,29 @@ func (l *LoggerBase) Output(calldepth int, s string) error {
 		l.buf = append(l.buf, '\n')
 	}
 
-	_, err := l.out.Write(l.buf)
+	return l.writeLocked(LEVEL_NOTICE)
+}
+
+// write l.buf to sinks, or hand a copy to the async queue.
+// must be called with l.mu held, which is released while queueing.
+func (l *LoggerBase) writeLocked(level int32) error {
+	data := l.buf
+	if q := l.queue; q != nil {
+		//释放锁期间l.buf可能被其他协程改写，队列关闭时同步写入的也须是副本
+		data = append([]byte(nil), l.buf...)
+		l.mu.Unlock()
+		ok := q.push(level, data)
+		l.mu.Lock()
+		if ok {
+			return nil
+		}
+	}
 
+	var err error
+	for _, sink := range l.sinks {
+		if e := sink.Write(level, data); e != nil {
+			err = e
+		}
+	}
 	return err
 }
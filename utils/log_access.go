@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+//访问日志：每个请求一条，字段依次为time、SetBaseInfo字段、method、path、status、errno、latency_ms、
+//PushNotice字段(按首次push顺序)、sections(StatusStart/StatusEnd的耗时，单位ms)；
+//json格式输出为json，其余为logfmt；未设置输出目标时写入主日志
+type accessLogger struct {
+	mu    sync.Mutex
+	sinks []LogSink
+	buf   []byte
+}
+
+var _access = &accessLogger{}
+
+func SetAccessLogSinks(sinks ...LogSink) {
+	_access.mu.Lock()
+	old := _access.sinks
+	_access.sinks = sinks
+	_access.mu.Unlock()
+
+	for _, sink := range old {
+		if !containsSink(sinks, sink) {
+			sink.Close()
+		}
+	}
+}
+
+//访问日志写入path，path为空时写入主日志
+func SetAccessLogFile(path string) error {
+	if len(path) == 0 {
+		SetAccessLogSinks()
+		return nil
+	}
+	sink, err := NewAccessFileSink(path)
+	if err != nil {
+		return err
+	}
+	SetAccessLogSinks(sink)
+	return nil
+}
+
+func accessSinks() []LogSink {
+	_access.mu.Lock()
+	defer _access.mu.Unlock()
+	return _access.sinks
+}
+
+//输出本请求的访问日志，每个Logger只输出一次，不受日志级别限制
+func (L *Logger) Access(method, path string, status int, errno string, latency time.Duration) {
+	L.mu.Lock()
+	if L.accessed {
+		L.mu.Unlock()
+		return
+	}
+	L.accessed = true
+	fields := orderedFields(L.reqinfo, L.reqkeys)
+	fields = append(fields,
+		logField{key: "method", value: method},
+		logField{key: "path", value: path},
+		logField{key: "status", value: status},
+		logField{key: "errno", value: errno},
+		logField{key: "latency_ms", value: latency.Milliseconds()},
+	)
+	fields = append(fields, orderedFields(L.noticeinfo, L.noticekeys)...)
+	sections := append([]logField(nil), L.sections...)
+	L.mu.Unlock()
+
+	_access.write(time.Now(), fields, sections)
+}
+
+func (a *accessLogger) write(t time.Time, fields, sections []logField) {
+	a.mu.Lock()
+	a.buf = a.buf[:0]
+	if isJsonLog() {
+		a.buf = append(a.buf, `{"time":`...)
+		a.buf = appendJsonValue(a.buf, t.Format(logTimeLayout))
+		for _, f := range fields {
+			a.buf = append(a.buf, ',')
+			a.buf = appendJsonValue(a.buf, f.key)
+			a.buf = append(a.buf, ':')
+			a.buf = appendJsonValue(a.buf, f.value)
+		}
+		a.buf = append(a.buf, `,"sections":{`...)
+		for i, f := range sections {
+			if i > 0 {
+				a.buf = append(a.buf, ',')
+			}
+			a.buf = appendJsonValue(a.buf, f.key)
+			a.buf = append(a.buf, ':')
+			a.buf = appendJsonValue(a.buf, f.value)
+		}
+		a.buf = append(a.buf, "}}\n"...)
+	} else {
+		a.buf = append(a.buf, "time="...)
+		a.buf = append(a.buf, t.Format(logTimeLayout)...)
+		for _, f := range fields {
+			a.buf = append(a.buf, ' ')
+			a.buf = append(a.buf, f.key...)
+			a.buf = append(a.buf, '=')
+			a.buf = appendLogfmtValue(a.buf, logValueString(f.value))
+		}
+		for _, f := range sections {
+			a.buf = append(a.buf, " section."...)
+			a.buf = append(a.buf, f.key...)
+			a.buf = append(a.buf, '=')
+			a.buf = appendLogfmtValue(a.buf, logValueString(f.value))
+		}
+		a.buf = append(a.buf, '\n')
+	}
+	data := append([]byte(nil), a.buf...)
+	sinks := a.sinks
+	a.mu.Unlock()
+
+	writeRecord(logRecord{sinks: sinks, level: LEVEL_NOTICE, data: data, keep: true})
+}
+
+//写入record.sinks，为nil时写入主日志的输出目标；异步模式下经由队列写入
+func writeRecord(record logRecord) {
+	_log.mu.Lock()
+	q := _log.queue
+	if record.sinks == nil {
+		record.sinks = _log.sinks
+	}
+	_log.mu.Unlock()
+
+	if q != nil && q.pushTo(record) {
+		return
+	}
+	for _, sink := range record.sinks {
+		sink.Write(record.level, record.data)
+	}
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+type bufSink struct {
+	lines []string
+}
+
+func (b *bufSink) Write(level int32, p []byte) error {
+	b.lines = append(b.lines, string(p))
+	return nil
+}
+
+func (b *bufSink) Reopen() error { return nil }
+
+func (b *bufSink) Close() error { return nil }
+
+func TestAccessLog(t *testing.T) {
+	access := &bufSink{}
+	SetAccessLogSinks(access)
+	defer SetAccessLogSinks()
+
+	L := NewLogger()
+	L.SetBaseInfo("logid", 123)
+	L.PushNotice("client", "127.0.0.1")
+	L.PushNotice("uid", 7)
+	L.PushNotice("client", "127.0.0.2")
+	L.PushSection("GetExample", 12*time.Millisecond)
+	L.Access("GET", "/rest/example/get", 200, "0", 35*time.Millisecond)
+	L.Access("GET", "/rest/example/get", 200, "0", 35*time.Millisecond)
+
+	assert.Equal(t, len(access.lines), 1)
+	line := access.lines[0]
+	assert.Equal(t, strings.HasSuffix(line,
+		" logid=123 method=GET path=/rest/example/get status=200 errno=0 latency_ms=35 client=127.0.0.2 uid=7 section.GetExample=12\n"), true)
+
+	SetLogFormat(LOG_FORMAT_JSON)
+	defer SetLogFormat(LOG_FORMAT_TEXT)
+	L = NewLogger()
+	L.PushSection("GetExample", 12*time.Millisecond)
+	L.Access("POST", "/rest/example/add", 504, "10014", time.Second)
+	var record map[string]interface{}
+	assert.NilError(t, json.Unmarshal([]byte(access.lines[1]), &record))
+	assert.Equal(t, record["status"], float64(504))
+	assert.Equal(t, record["errno"], "10014")
+	assert.DeepEqual(t, record["sections"], map[string]interface{}{"GetExample": float64(12)})
+}
+
+func TestNoticeNotAccumulate(t *testing.T) {
+	L := NewLogger()
+	L.SetBaseInfo("logid", 123)
+	L.PushNotice("client", "127.0.0.1")
+	L.Notice("first")
+	L.Notice("second")
+	assert.Equal(t, L.buf, "[logid:123] ")
+	assert.Equal(t, L.formatNoticeInfo(), "[client:127.0.0.1] ")
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//异步日志队列满时的处理方式
+const (
+	LOG_OVERFLOW_BLOCK = "block" //等待写入协程腾出空间
+	LOG_OVERFLOW_DROP  = "drop"  //丢弃队列中(含新日志)级别最低的一条
+)
+
+//各级别被丢弃的日志条数
+var logDropped [LEVEL_VERBOSE + 1]uint64
+
+type logRecord struct {
+	sinks []LogSink //nil时写入主日志的输出目标
+	level int32
+	data  []byte
+	keep  bool //访问日志，队列满时不被丢弃，也不丢弃其他日志，只等待
+}
+
+//有界环形队列，由单个写入协程消费
+type logQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      []logRecord
+	head     int
+	count    int
+	overflow string
+	writing  bool
+	closed   bool
+	reported uint64
+}
+
+func newLogQueue(size int, overflow string) *logQueue {
+	q := &logQueue{buf: make([]logRecord, size), overflow: overflow, reported: LogDropped()}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+//开启异步日志，size为队列长度，size<=0时关闭异步并写完队列中的日志
+func SetLogAsync(size int, overflow string) {
+	var q *logQueue
+	if size > 0 {
+		q = newLogQueue(size, overflow)
+		go q.run()
+	}
+
+	_log.mu.Lock()
+	old := _log.queue
+	_log.queue = q
+	_log.mu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+}
+
+//等待异步队列中的日志全部写出，同步模式下直接返回
+func Flush() {
+	_log.mu.Lock()
+	q := _log.queue
+	_log.mu.Unlock()
+	if q != nil {
+		q.flush()
+	}
+}
+
+//被丢弃的日志总条数
+func LogDropped() uint64 {
+	var total uint64
+	for i := range logDropped {
+		total += atomic.LoadUint64(&logDropped[i])
+	}
+	return total
+}
+
+func LogDroppedByLevel(level int32) uint64 {
+	if level < 0 || int(level) >= len(logDropped) {
+		return 0
+	}
+	return atomic.LoadUint64(&logDropped[level])
+}
+
+func countDropped(level int32) {
+	if level >= 0 && int(level) < len(logDropped) {
+		atomic.AddUint64(&logDropped[level], 1)
+	}
+}
+
+func (q *logQueue) push(level int32, data []byte) bool {
+	return q.pushTo(logRecord{level: level, data: data})
+}
+
+//队列已关闭时返回false，由调用方同步写入
+func (q *logQueue) pushTo(record logRecord) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	level := record.level
+	for q.count == len(q.buf) && !q.closed {
+		if q.overflow == LOG_OVERFLOW_DROP && !record.keep {
+			if !q.evict(level) {
+				return true
+			}
+			break
+		}
+		q.cond.Wait()
+	}
+	if q.closed {
+		return false
+	}
+	q.buf[(q.head+q.count)%len(q.buf)] = record
+	q.count++
+	q.cond.Broadcast()
+	return true
+}
+
+//丢弃级别最低(数值最大)的一条，跳过访问日志；新日志级别最低时丢弃新日志并返回false
+func (q *logQueue) evict(level int32) bool {
+	lowest := -1
+	for i := 0; i < q.count; i++ {
+		idx := (q.head + i) % len(q.buf)
+		if q.buf[idx].keep {
+			continue
+		}
+		if lowest < 0 || q.buf[idx].level > q.buf[(q.head+lowest)%len(q.buf)].level {
+			lowest = i
+		}
+	}
+	if lowest < 0 {
+		countDropped(level)
+		return false
+	}
+	evicted := q.buf[(q.head+lowest)%len(q.buf)].level
+	if evicted <= level {
+		countDropped(level)
+		return false
+	}
+	for i := lowest; i < q.count-1; i++ {
+		q.buf[(q.head+i)%len(q.buf)] = q.buf[(q.head+i+1)%len(q.buf)]
+	}
+	q.count--
+	q.buf[(q.head+q.count)%len(q.buf)] = logRecord{}
+	countDropped(evicted)
+	return true
+}
+
+//取出队列中全部日志，队列关闭且为空时返回nil
+func (q *logQueue) popAll() []logRecord {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.count == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.count == 0 {
+		return nil
+	}
+	records := make([]logRecord, 0, q.count)
+	for ; q.count > 0; q.count-- {
+		records = append(records, q.buf[q.head])
+		q.buf[q.head] = logRecord{}
+		q.head = (q.head + 1) % len(q.buf)
+	}
+	q.writing = true
+	q.cond.Broadcast()
+	return records
+}
+
+func (q *logQueue) run() {
+	for {
+		records := q.popAll()
+		if records == nil {
+			return
+		}
+		_log.mu.Lock()
+		sinks := _log.sinks
+		_log.mu.Unlock()
+		for _, record := range records {
+			targets := record.sinks
+			if targets == nil {
+				targets = sinks
+			}
+			for _, sink := range targets {
+				sink.Write(record.level, record.data)
+			}
+		}
+
+		q.mu.Lock()
+		q.writing = false
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		if dropped := LogDropped(); dropped > q.reported {
+			Warn("async log dropped %d lines, total:%d", dropped-q.reported, dropped)
+			q.reported = dropped
+		}
+	}
+}
+
+func (q *logQueue) flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.count > 0 || q.writing {
+		q.cond.Wait()
+	}
+}
+
+func (q *logQueue) close() {
+	q.flush()
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
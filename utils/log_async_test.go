@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+type memSink struct {
+	levels []int32
+}
+
+func (m *memSink) Write(level int32, p []byte) error {
+	m.levels = append(m.levels, level)
+	return nil
+}
+
+func (m *memSink) Reopen() error { return nil }
+
+func (m *memSink) Close() error { return nil }
+
+func TestLogQueueDropLowest(t *testing.T) {
+	q := newLogQueue(3, LOG_OVERFLOW_DROP)
+	before := LogDroppedByLevel(LEVEL_DEBUG)
+	q.push(LEVEL_NOTICE, nil)
+	q.push(LEVEL_DEBUG, nil)
+	q.push(LEVEL_WARNING, nil)
+
+	//队列满时丢弃级别最低的DEBUG
+	q.push(LEVEL_CRITICAL, nil)
+	//新日志级别最低时丢弃新日志
+	q.push(LEVEL_VERBOSE, nil)
+
+	var levels []int32
+	for i := 0; i < q.count; i++ {
+		levels = append(levels, q.buf[(q.head+i)%len(q.buf)].level)
+	}
+	assert.DeepEqual(t, levels, []int32{LEVEL_NOTICE, LEVEL_WARNING, LEVEL_CRITICAL})
+	assert.Equal(t, LogDroppedByLevel(LEVEL_DEBUG), before+1)
+}
+
+func TestLogAsyncFlush(t *testing.T) {
+	old := logSinks()
+	t.Cleanup(func() { SetLogSinks(old...) })
+	sink := &memSink{}
+	SetLogSinks(sink)
+	SetLogAsync(16, LOG_OVERFLOW_BLOCK)
+	defer SetLogAsync(0, "")
+
+	for i := 0; i < 100; i++ {
+		Warn("async %d", i)
+	}
+	Flush()
+	assert.Equal(t, len(sink.levels), 100)
+}
+
+func TestLogQueueKeepAccess(t *testing.T) {
+	q := newLogQueue(2, LOG_OVERFLOW_DROP)
+	q.pushTo(logRecord{level: LEVEL_NOTICE, keep: true})
+	q.push(LEVEL_DEBUG, nil)
+
+	//访问日志不被丢弃，丢弃普通日志中级别最低的
+	q.push(LEVEL_WARNING, nil)
+	assert.Equal(t, q.buf[q.head].keep, true)
+	assert.Equal(t, q.buf[(q.head+1)%len(q.buf)].level, int32(LEVEL_WARNING))
+
+	//队列满时访问日志等待写入协程腾出空间
+	done := make(chan bool)
+	go func() {
+		done <- q.pushTo(logRecord{level: LEVEL_NOTICE, keep: true})
+	}()
+	select {
+	case <-done:
+		t.Fatal("access record should wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	records := q.popAll()
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, <-done, true)
+	assert.Equal(t, q.count, 1)
+	assert.Equal(t, q.buf[q.head].keep, true)
+}
+
+type lineSink struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *lineSink) Write(level int32, p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, string(p))
+	return nil
+}
+
+func (s *lineSink) Reopen() error { return nil }
+
+func (s *lineSink) Close() error { return nil }
+
+func TestLogClosedQueueFallback(t *testing.T) {
+	old := logSinks()
+	t.Cleanup(func() { SetLogSinks(old...) })
+	sink := &lineSink{}
+	SetLogSinks(sink)
+
+	//队列已关闭时同步写入；a、b都在等待队列时l.buf已是b的内容，a须写入自己的副本
+	q := newLogQueue(4, LOG_OVERFLOW_BLOCK)
+	q.close()
+	_log.mu.Lock()
+	oldQueue := _log.queue
+	_log.queue = q
+	_log.mu.Unlock()
+	defer func() {
+		_log.mu.Lock()
+		_log.queue = oldQueue
+		_log.mu.Unlock()
+	}()
+
+	q.mu.Lock()
+	var wg sync.WaitGroup
+	for _, msg := range []string{"fallback a", "fallback b"} {
+		wg.Add(1)
+		go func(msg string) {
+			defer wg.Done()
+			Warn(msg)
+		}(msg)
+		time.Sleep(20 * time.Millisecond)
+	}
+	q.mu.Unlock()
+	wg.Wait()
+
+	var got []string
+	for _, line := range sink.lines {
+		i := strings.Index(line, "fallback ")
+		assert.Assert(t, i >= 0, line)
+		got = append(got, strings.TrimSpace(line[i:]))
+	}
+	sort.Strings(got)
+	assert.DeepEqual(t, got, []string{"fallback a", "fallback b"})
+}
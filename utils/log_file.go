@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//切分后的归档文件后缀：时间[.序号][.gz]，例：gomvc.log.2020010203、gomvc.log.wf.20200102030405.1.gz
+var archiveFilter = regexp.MustCompile(`^\d{8}(\d{2}){0,3}(\.\d+)?(\.gz)?$`)
+
+//全部FileSink共用的切分设置
+var (
+	rotateSize  int64 //单个文件超过该大小时切分，0不按大小切分
+	rotateTotal int64 //归档文件总大小上限，超过时删除最旧的归档，0不限制
+	rotateGzip  int32 //归档后后台gzip压缩
+)
+
+//按大小切分，bytes<=0不按大小切分
+func SetLogRotateSize(bytes int64) {
+	atomic.StoreInt64(&rotateSize, bytes)
+}
+
+//path及path.wf全部归档的总大小上限，bytes<=0不限制
+func SetLogTotalSize(bytes int64) {
+	atomic.StoreInt64(&rotateTotal, bytes)
+}
+
+func SetLogCompress(compress bool) {
+	var v int32
+	if compress {
+		v = 1
+	}
+	atomic.StoreInt32(&rotateGzip, v)
+}
+
+//文件输出：NOTICE及以下级别写path，WARNING及以上写path.wf；
+//切分在持有mu时完成改名和重新打开，与并发写入互斥，压缩和清理在后台进行
+type FileSink struct {
+	path    string
+	single  bool //全部写path，不打开path.wf
+	out     *os.File
+	out_wf  *os.File
+	size    int64
+	size_wf int64
+	mu      sync.Mutex
+
+	//串行化后台的压缩与清理
+	archiveMu sync.Mutex
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f := &FileSink{path: path}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+//不区分级别全部写入path，用于访问日志
+func NewAccessFileSink(path string) (*FileSink, error) {
+	f := &FileSink{path: path, single: true}
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileSink) Path() string {
+	return f.path
+}
+
+func (f *FileSink) Write(level int32, p []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	var n int
+	if level >= LEVEL_NOTICE || f.single {
+		n, err = f.out.Write(p)
+		f.size += int64(n)
+	} else if level <= LEVEL_WARNING {
+		n, err = f.out_wf.Write(p)
+		f.size_wf += int64(n)
+	}
+
+	limit := atomic.LoadInt64(&rotateSize)
+	if limit > 0 && (f.size >= limit || f.size_wf >= limit) {
+		suffix := time.Now().Format("20060102150405")
+		if f.size >= limit {
+			f.rotateLocked(f.path, &f.out, &f.size, suffix)
+		}
+		if f.size_wf >= limit {
+			f.rotateLocked(f.path+".wf", &f.out_wf, &f.size_wf, suffix)
+		}
+	}
+	return err
+}
+
+//按周期切分，suffix为归档时间后缀
+func (f *FileSink) Rotate(suffix string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotateLocked(f.path, &f.out, &f.size, suffix)
+	if !f.single {
+		f.rotateLocked(f.path+".wf", &f.out_wf, &f.size_wf, suffix)
+	}
+}
+
+//改名后立即重新打开，改名失败时继续写原文件
+func (f *FileSink) rotateLocked(path string, out **os.File, size *int64, suffix string) {
+	archive := archiveName(path, suffix)
+	if err := os.Rename(path, archive); err != nil {
+		fmt.Fprintf(os.Stderr, "rotate log %s fail: %s\n", path, err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reopen log %s fail: %s\n", path, err)
+		return
+	}
+	(*out).Close()
+	*out = file
+	*size = 0
+	go f.archive(archive)
+}
+
+//同一后缀已存在时追加序号
+func archiveName(path, suffix string) string {
+	name := path + "." + suffix
+	for i := 1; ; i++ {
+		_, err := os.Stat(name)
+		_, errGz := os.Stat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(errGz) {
+			return name
+		}
+		name = path + "." + suffix + "." + strconv.Itoa(i)
+	}
+}
+
+func (f *FileSink) archive(name string) {
+	f.archiveMu.Lock()
+	defer f.archiveMu.Unlock()
+
+	if atomic.LoadInt32(&rotateGzip) == 1 {
+		//超出总大小时可能已被之前的清理删除
+		if err := gzipFile(name); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "compress log %s fail: %s\n", name, err)
+		}
+	}
+	f.cleanup()
+}
+
+//按备份个数和总大小删除最旧的归档
+func (f *FileSink) cleanup() {
+	for _, path := range f.paths() {
+		for _, fileName := range getFilesToDelete(path, archiveFilter, _log.backupCount) {
+			os.Remove(fileName)
+		}
+	}
+
+	limit := atomic.LoadInt64(&rotateTotal)
+	if limit <= 0 {
+		return
+	}
+	var archives []os.FileInfo
+	var total int64
+	for _, path := range f.paths() {
+		for _, info := range listArchives(path) {
+			archives = append(archives, info)
+			total += info.Size()
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].ModTime().Before(archives[j].ModTime())
+	})
+	dir := filepath.Dir(f.path)
+	for _, info := range archives {
+		if total <= limit {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, info.Name())); err == nil {
+			total -= info.Size()
+		}
+	}
+}
+
+func (f *FileSink) paths() []string {
+	if f.single {
+		return []string{f.path}
+	}
+	return []string{f.path, f.path + ".wf"}
+}
+
+func listArchives(path string) []os.FileInfo {
+	fileInfos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+	prefix := filepath.Base(path) + "."
+	var result []os.FileInfo
+	for _, info := range fileInfos {
+		name := info.Name()
+		if len(name) > len(prefix) && name[:len(prefix)] == prefix && archiveFilter.MatchString(name[len(prefix):]) {
+			result = append(result, info)
+		}
+	}
+	return result
+}
+
+//压缩为name.gz后删除name，先写临时文件避免留下不完整的归档
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+func (f *FileSink) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	var out_wf *os.File
+	if !f.single {
+		out_wf, err = os.OpenFile(f.path+".wf", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	f.closeLocked()
+	f.out, f.out_wf = out, out_wf
+	f.size, f.size_wf = fileSize(out), fileSize(out_wf)
+	return nil
+}
+
+func fileSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (f *FileSink) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closeLocked()
+	return nil
+}
+
+func (f *FileSink) closeLocked() {
+	if f.out != nil {
+		f.out.Close()
+	}
+	if f.out_wf != nil {
+		f.out_wf.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestFileSinkRotateSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomvc_rotate")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	SetLogRotateSize(1024)
+	SetLogCompress(true)
+	SetLogTotalSize(4096)
+	defer SetLogRotateSize(0)
+	defer SetLogCompress(false)
+	defer SetLogTotalSize(0)
+
+	path := filepath.Join(dir, "gomvc.log")
+	f, err := NewFileSink(path)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	//并发写入时切分不丢失、不交错
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				f.Write(LEVEL_NOTICE, line)
+			}
+		}()
+	}
+	wg.Wait()
+
+	//等待后台压缩和清理
+	time.Sleep(100 * time.Millisecond)
+	f.archiveMu.Lock()
+	defer f.archiveMu.Unlock()
+
+	archives := listArchives(path)
+	assert.Equal(t, len(archives) > 0, true)
+	var total int64
+	for _, info := range archives {
+		assert.Equal(t, strings.HasSuffix(info.Name(), ".gz"), true)
+		total += info.Size()
+	}
+	assert.Equal(t, total <= 4096, true)
+
+	b, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Equal(t, len(b)%len(line), 0)
+	assert.Equal(t, len(b) < 1024, true)
+}
+
+func TestAccessFileSinkNoWf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomvc_access")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "access.log")
+	f, err := NewAccessFileSink(path)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	f.Write(LEVEL_WARNING, []byte("warning\n"))
+	f.Rotate("20200102030405")
+	f.Write(LEVEL_NOTICE, []byte("notice\n"))
+	assert.NilError(t, f.Reopen())
+
+	_, err = os.Stat(path + ".wf")
+	assert.Assert(t, os.IsNotExist(err))
+	b, err := ioutil.ReadFile(path + ".20200102030405")
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "warning\n")
+	b, err = ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "notice\n")
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//日志输出格式，text为原有的[LEVEL] time file:line [k:v] msg格式
+const (
+	LOG_FORMAT_TEXT   = "text"
+	LOG_FORMAT_JSON   = "json"
+	LOG_FORMAT_LOGFMT = "logfmt"
+)
+
+const logTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+//结构化输出的固定字段，SetBaseInfo/PushNotice使用同名key时加上"_"前缀
+var reservedLogKeys = map[string]bool{
+	"time":   true,
+	"level":  true,
+	"caller": true,
+	"msg":    true,
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+//设置日志输出格式：text(默认)|json|logfmt
+func SetLogFormat(format string) {
+	switch format {
+	case LOG_FORMAT_JSON, LOG_FORMAT_LOGFMT:
+	default:
+		format = LOG_FORMAT_TEXT
+	}
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+	_log.format = format
+}
+
+func isJsonLog() bool {
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+	return _log.format == LOG_FORMAT_JSON
+}
+
+func isStructuredLog() bool {
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+	return _log.format == LOG_FORMAT_JSON || _log.format == LOG_FORMAT_LOGFMT
+}
+
+//按设置顺序的请求字段，notice为true时包含PushNotice的字段和分段耗时；text格式不需要，返回nil
+func (L *Logger) fields(notice bool) []logField {
+	if !isStructuredLog() {
+		return nil
+	}
+	L.mu.Lock()
+	defer L.mu.Unlock()
+
+	fields := orderedFields(L.reqinfo, L.reqkeys)
+	if notice {
+		fields = append(fields, orderedFields(L.noticeinfo, L.noticekeys)...)
+		for _, section := range L.sections {
+			fields = append(fields, logField{key: section.key, value: fmt.Sprintf("%vms", section.value)})
+		}
+	}
+	return fields
+}
+
+func orderedFields(info map[string]interface{}, keys []string) []logField {
+	fields := make([]logField, 0, len(keys))
+	for _, k := range keys {
+		key := k
+		if reservedLogKeys[key] {
+			key = "_" + key
+		}
+		fields = append(fields, logField{key: key, value: info[k]})
+	}
+	return fields
+}
+
+func (l *LoggerBase) callerFile(file string) string {
+	if l.printCatal {
+		return file[strings.LastIndex(file, "src"):]
+	}
+	if i := strings.LastIndex(file, "/"); i > 0 {
+		return file[i+1:]
+	}
+	return file
+}
+
+func levelName(level int32) string {
+	return strings.Trim(levelStrings[level], "[]")
+}
+
+func (l *LoggerBase) formatJson(buf *[]byte, t time.Time, level int32, file string, line int, fields []logField, msg string) {
+	*buf = append(*buf, `{"time":`...)
+	*buf = appendJsonValue(*buf, t.Format(logTimeLayout))
+	*buf = append(*buf, `,"level":`...)
+	*buf = appendJsonValue(*buf, levelName(level))
+	*buf = append(*buf, `,"caller":`...)
+	*buf = appendJsonValue(*buf, l.callerFile(file)+":"+strconv.Itoa(line))
+	for _, f := range fields {
+		*buf = append(*buf, ',')
+		*buf = appendJsonValue(*buf, f.key)
+		*buf = append(*buf, ':')
+		*buf = appendJsonValue(*buf, f.value)
+	}
+	*buf = append(*buf, `,"msg":`...)
+	*buf = appendJsonValue(*buf, strings.TrimSuffix(msg, "\n"))
+	*buf = append(*buf, "}\n"...)
+}
+
+func (l *LoggerBase) formatLogfmt(buf *[]byte, t time.Time, level int32, file string, line int, fields []logField, msg string) {
+	*buf = append(*buf, "time="...)
+	*buf = append(*buf, t.Format(logTimeLayout)...)
+	*buf = append(*buf, " level="...)
+	*buf = append(*buf, levelName(level)...)
+	*buf = append(*buf, " caller="...)
+	*buf = appendLogfmtValue(*buf, l.callerFile(file)+":"+strconv.Itoa(line))
+	for _, f := range fields {
+		*buf = append(*buf, ' ')
+		*buf = append(*buf, f.key...)
+		*buf = append(*buf, '=')
+		*buf = appendLogfmtValue(*buf, logValueString(f.value))
+	}
+	*buf = append(*buf, " msg="...)
+	*buf = appendLogfmtValue(*buf, strings.TrimSuffix(msg, "\n"))
+	*buf = append(*buf, '\n')
+}
+
+//数值、bool等保持原类型，error、Duration输出为字符串，无法编码的按%v输出
+func appendJsonValue(buf []byte, v interface{}) []byte {
+	switch val := v.(type) {
+	case error:
+		v = val.Error()
+	case time.Duration:
+		v = val.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%v", v))
+	}
+	return append(buf, b...)
+}
+
+func logValueString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+//包含空白、引号、等号或不可打印字符的值加引号
+func appendLogfmtValue(buf []byte, s string) []byte {
+	if len(s) == 0 {
+		return append(buf, `""`...)
+	}
+	for _, r := range s {
+		if r == '"' || r == '=' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.AppendQuote(buf, s)
+		}
+	}
+	return append(buf, s...)
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestLogFormat(t *testing.T) {
+	l := &LoggerBase{}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	fields := []logField{
+		{key: "logid", value: "123"},
+		{key: "cost", value: 15},
+		{key: "err", value: errors.New("not found")},
+	}
+
+	var buf []byte
+	l.formatJson(&buf, now, LEVEL_WARNING, "/a/b/api.go", 12, fields, "get fail\n")
+	var record map[string]interface{}
+	assert.NilError(t, json.Unmarshal(buf, &record))
+	assert.Equal(t, record["time"], "2020-01-02T03:04:05.000006Z")
+	assert.Equal(t, record["level"], "WARNING")
+	assert.Equal(t, record["caller"], "api.go:12")
+	assert.Equal(t, record["logid"], "123")
+	assert.Equal(t, record["cost"], float64(15))
+	assert.Equal(t, record["err"], "not found")
+	assert.Equal(t, record["msg"], "get fail")
+
+	buf = buf[:0]
+	l.formatLogfmt(&buf, now, LEVEL_NOTICE, "/a/b/api.go", 12, fields, "get fail")
+	assert.Equal(t, string(buf),
+		`time=2020-01-02T03:04:05.000006Z level=NOTICE caller=api.go:12 logid=123 cost=15 err="not found" msg="get fail"`+"\n")
+}
+
+func TestLoggerFields(t *testing.T) {
+	SetLogFormat(LOG_FORMAT_JSON)
+	defer SetLogFormat(LOG_FORMAT_TEXT)
+
+	L := NewLogger()
+	L.SetBaseInfo("logid", 1)
+	L.PushNotice("msg", "ok")
+	L.PushNotice("client", "127.0.0.1")
+	assert.Equal(t, fmt.Sprint(L.fields(false)), "[{logid 1}]")
+	assert.Equal(t, fmt.Sprint(L.fields(true)), "[{logid 1} {_msg ok} {client 127.0.0.1}]")
+
+	SetLogFormat(LOG_FORMAT_TEXT)
+	assert.Equal(t, len(L.fields(true)), 0)
+}
@@ -0,0 +1,340 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//日志输出目标，LoggerBase将格式化后的每条日志依次写入全部sink
+type LogSink interface {
+	Write(level int32, p []byte) error
+	//SIGHUP、切分后重新打开
+	Reopen() error
+	Close() error
+}
+
+const (
+	LOG_SINK_FILE   = "file"
+	LOG_SINK_STDOUT = "stdout"
+	LOG_SINK_SYSLOG = "syslog"
+	LOG_SINK_TCP    = "tcp"
+	LOG_SINK_UDP    = "udp"
+
+	netSinkTimeout   = time.Second
+	netSinkQueueSize = 1024
+	netSinkRetryMin  = time.Second
+	netSinkRetryMax  = 30 * time.Second
+)
+
+//标准输出，用于容器：NOTICE及以下级别写stdout，WARNING及以上写stderr
+type StdoutSink struct {
+	out    *os.File
+	errOut *os.File
+}
+
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{out: os.Stdout, errOut: os.Stderr}
+}
+
+func (s *StdoutSink) Write(level int32, p []byte) error {
+	var err error
+	if level >= LEVEL_NOTICE {
+		_, err = s.out.Write(p)
+	} else {
+		_, err = s.errOut.Write(p)
+	}
+	return err
+}
+
+func (s *StdoutSink) Reopen() error { return nil }
+
+func (s *StdoutSink) Close() error { return nil }
+
+//syslog输出，network为空时使用本机syslog
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+func NewSyslogSink(network, addr, tag string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) Write(level int32, p []byte) error {
+	m := string(p)
+	switch level {
+	case LEVEL_EMERGENCY:
+		return s.w.Emerg(m)
+	case LEVEL_ALERT:
+		return s.w.Alert(m)
+	case LEVEL_CRITICAL:
+		return s.w.Crit(m)
+	case LEVEL_WARNING:
+		return s.w.Warning(m)
+	case LEVEL_NOTICE:
+		return s.w.Notice(m)
+	case LEVEL_INFO:
+		return s.w.Info(m)
+	default:
+		return s.w.Debug(m)
+	}
+}
+
+//syslog.Writer写失败时自行重连
+func (s *SyslogSink) Reopen() error { return nil }
+
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
+
+//tcp/udp远程输出，每条日志一行；由后台协程发送，队列满时丢弃新日志；
+//连接失败后按退避间隔重连，重连之前的日志直接丢弃，不阻塞写日志的请求
+type NetSink struct {
+	network    string
+	addr       string
+	lines      chan logRecord
+	done       chan struct{}
+	closeOnce  sync.Once
+	conn       net.Conn
+	retryAt    time.Time
+	retryDelay time.Duration
+	mu         sync.Mutex
+}
+
+func NewNetSink(network, addr string) *NetSink {
+	n := &NetSink{
+		network: network,
+		addr:    addr,
+		lines:   make(chan logRecord, netSinkQueueSize),
+		done:    make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+var errNetSinkFull = errors.New("net sink queue full")
+
+func (n *NetSink) Write(level int32, p []byte) error {
+	select {
+	case <-n.done:
+		return os.ErrClosed
+	default:
+	}
+	select {
+	case n.lines <- logRecord{level: level, data: append([]byte(nil), p...)}:
+		return nil
+	default:
+		countDropped(level)
+		return errNetSinkFull
+	}
+}
+
+func (n *NetSink) run() {
+	for {
+		select {
+		case <-n.done:
+			return
+		case record := <-n.lines:
+			if err := n.send(record.data); err != nil {
+				countDropped(record.level)
+			}
+		}
+	}
+}
+
+func (n *NetSink) send(p []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.done:
+		return os.ErrClosed
+	default:
+	}
+	if n.conn == nil {
+		if time.Now().Before(n.retryAt) {
+			return errNetSinkRetry
+		}
+		conn, err := net.DialTimeout(n.network, n.addr, netSinkTimeout)
+		if err != nil {
+			n.backoffLocked()
+			return err
+		}
+		n.conn, n.retryDelay = conn, 0
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(netSinkTimeout))
+	if _, err := n.conn.Write(p); err != nil {
+		n.conn.Close()
+		n.conn = nil
+		n.backoffLocked()
+		return err
+	}
+	return nil
+}
+
+var errNetSinkRetry = errors.New("net sink waiting for reconnect")
+
+//重连间隔从netSinkRetryMin开始翻倍，最大netSinkRetryMax
+func (n *NetSink) backoffLocked() {
+	n.retryDelay *= 2
+	if n.retryDelay < netSinkRetryMin {
+		n.retryDelay = netSinkRetryMin
+	}
+	if n.retryDelay > netSinkRetryMax {
+		n.retryDelay = netSinkRetryMax
+	}
+	n.retryAt = time.Now().Add(n.retryDelay)
+}
+
+//断开连接，下次写入时立即重连
+func (n *NetSink) Reopen() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	n.retryAt, n.retryDelay = time.Time{}, 0
+	return nil
+}
+
+//停止发送协程，队列中未发送的日志丢弃
+func (n *NetSink) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.done)
+	})
+	return n.Reopen()
+}
+
+//只输出不低于Level严重程度的日志，例：Level为LEVEL_WARNING时只输出WARNING及以上
+type LevelSink struct {
+	LogSink
+	Level int32
+}
+
+func (l *LevelSink) Write(level int32, p []byte) error {
+	if level > l.Level {
+		return nil
+	}
+	return l.LogSink.Write(level, p)
+}
+
+//替换全部输出目标，旧的sink被关闭
+func SetLogSinks(sinks ...LogSink) {
+	_log.mu.Lock()
+	old := _log.sinks
+	_log.sinks = sinks
+	_log.path = ""
+	for _, sink := range sinks {
+		if f, ok := unwrapSink(sink).(*FileSink); ok {
+			_log.path = f.Path()
+			break
+		}
+	}
+	_log.mu.Unlock()
+
+	for _, sink := range old {
+		if !containsSink(sinks, sink) {
+			sink.Close()
+		}
+	}
+}
+
+func logSinks() []LogSink {
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+	return _log.sinks
+}
+
+func AddLogSink(sink LogSink) {
+	_log.mu.Lock()
+	sinks := append(append([]LogSink{}, _log.sinks...), sink)
+	_log.mu.Unlock()
+	SetLogSinks(sinks...)
+}
+
+func unwrapSink(sink LogSink) LogSink {
+	if l, ok := sink.(*LevelSink); ok {
+		return unwrapSink(l.LogSink)
+	}
+	return sink
+}
+
+func containsSink(sinks []LogSink, sink LogSink) bool {
+	for _, s := range sinks {
+		if s == sink {
+			return true
+		}
+	}
+	return false
+}
+
+//按conf中LOG_SINK创建输出目标，未配置时输出到LOG_FILE_NAME
+func InitLogSinks() error {
+	SetLogRotateSize(int64(conf.ApiConf.LOG_ROTATE_SIZE_MB) << 20)
+	SetLogTotalSize(int64(conf.ApiConf.LOG_MAX_TOTAL_MB) << 20)
+	SetLogCompress(conf.ApiConf.LOG_COMPRESS)
+	if err := SetAccessLogFile(conf.ApiConf.ACCESS_LOG_FILE_NAME); err != nil {
+		return err
+	}
+
+	if len(conf.ApiConf.LOG_SINK) == 0 {
+		sink, err := NewFileSink(conf.ApiConf.LOG_FILE_NAME)
+		if err != nil {
+			return err
+		}
+		SetLogSinks(sink)
+		return nil
+	}
+
+	var sinks []LogSink
+	for _, c := range conf.ApiConf.LOG_SINK {
+		sink, err := newLogSink(c)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return fmt.Errorf("init log sink %s fail: %v", c.TYPE, err)
+		}
+		if c.LEVEL > 0 {
+			sink = &LevelSink{LogSink: sink, Level: c.LEVEL}
+		}
+		sinks = append(sinks, sink)
+	}
+	SetLogSinks(sinks...)
+	return nil
+}
+
+func newLogSink(c conf.Conf_Log_Sink) (LogSink, error) {
+	switch c.TYPE {
+	case LOG_SINK_FILE:
+		path := c.PATH
+		if len(path) == 0 {
+			path = conf.ApiConf.LOG_FILE_NAME
+		}
+		return NewFileSink(path)
+	case LOG_SINK_STDOUT:
+		return NewStdoutSink(), nil
+	case LOG_SINK_SYSLOG:
+		network := ""
+		if len(c.ADDR) > 0 {
+			network = LOG_SINK_UDP
+		}
+		return NewSyslogSink(network, c.ADDR, c.TAG)
+	case LOG_SINK_TCP, LOG_SINK_UDP:
+		if len(c.ADDR) == 0 {
+			return nil, errors.New("empty addr")
+		}
+		return NewNetSink(c.TYPE, c.ADDR), nil
+	}
+	return nil, errors.New("unknown type")
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestLogSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomvc_log")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+
+	file, err := NewFileSink(filepath.Join(dir, "gomvc.log"))
+	assert.NilError(t, err)
+	//远程只接收WARNING及以上
+	remote := &LevelSink{LogSink: NewNetSink("udp", l.LocalAddr().String()), Level: LEVEL_WARNING}
+	old := logSinks()
+	t.Cleanup(func() { SetLogSinks(old...) })
+	SetLogSinks(file, remote)
+
+	Notice("sink notice")
+	Warn("sink warning")
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "gomvc.log"))
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Contains(string(b), "sink notice"), true)
+	b, err = ioutil.ReadFile(filepath.Join(dir, "gomvc.log.wf"))
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Contains(string(b), "sink warning"), true)
+
+	l.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := l.ReadFrom(buf)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Contains(string(buf[:n]), "[WARNING]"), true)
+	assert.Equal(t, strings.Contains(string(buf[:n]), "sink warning"), true)
+}
+
+func TestNetSinkBackoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	sink := NewNetSink("tcp", addr)
+	defer sink.Close()
+
+	//远程不可用时写入不阻塞，只重连一次，之后的日志在重连前丢弃
+	before := LogDroppedByLevel(LEVEL_WARNING)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		assert.NilError(t, sink.Write(LEVEL_WARNING, []byte("line\n")))
+	}
+	assert.Assert(t, time.Since(start) < 100*time.Millisecond)
+	for LogDroppedByLevel(LEVEL_WARNING) < before+10 {
+		time.Sleep(time.Millisecond)
+	}
+	sink.mu.Lock()
+	assert.Equal(t, sink.retryDelay, netSinkRetryMin)
+	assert.Assert(t, sink.retryAt.After(time.Now()))
+	sink.mu.Unlock()
+
+	//Reopen后立即重连
+	l, err = net.Listen("tcp", addr)
+	assert.NilError(t, err)
+	defer l.Close()
+	assert.NilError(t, sink.Reopen())
+	assert.NilError(t, sink.Write(LEVEL_WARNING, []byte("recovered\n")))
+	conn, err := l.Accept()
+	assert.NilError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NilError(t, err)
+	assert.Equal(t, string(buf[:n]), "recovered\n")
+
+	sink.Close()
+	assert.Equal(t, sink.Write(LEVEL_WARNING, []byte("closed\n")), os.ErrClosed)
+}
@@ -0,0 +1,316 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//prometheus文本格式的指标路由
+const METRICS_PATH = "/metrics"
+
+//耗时直方图的默认分桶，单位秒
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+var (
+	metrics          []metric
+	metricCollectors []func()
+	metricsMu        sync.Mutex
+)
+
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics = append(metrics, m)
+}
+
+//注册采集函数，每次输出指标前调用，用于设置连接池状态等即时值的Gauge及外部累计值的Counter
+func OnMetricsCollect(fn func()) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricCollectors = append(metricCollectors, fn)
+}
+
+//按注册顺序输出全部指标
+func WriteMetrics(w io.Writer) error {
+	metricsMu.Lock()
+	collectors := append([]func(){}, metricCollectors...)
+	all := append([]metric{}, metrics...)
+	metricsMu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+	var buf bytes.Buffer
+	for _, m := range all {
+		m.write(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (r *Router) addMetricsRoute() {
+	r.engine.GET(METRICS_PATH, func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		WriteMetrics(c.Writer)
+	})
+	r.routes = append(r.routes, RouteInfo{Method: http.MethodGet, Path: METRICS_PATH, Actor: "metrics"})
+}
+
+//同一指标下按标签值区分的序列
+type metricVec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	series map[string]interface{}
+	mu     sync.Mutex
+}
+
+func newMetricVec(name, help, typ string, labels []string) metricVec {
+	return metricVec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: map[string]interface{}{},
+	}
+}
+
+//调用时持有mu；标签值个数不足时补空串，多余的忽略
+func (v *metricVec) get(values []string, create func(labels []string) interface{}) interface{} {
+	labels := make([]string, len(v.labels))
+	copy(labels, values)
+	key := strings.Join(labels, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = create(labels)
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *metricVec) writeHeader(buf *bytes.Buffer) {
+	buf.WriteString("# HELP " + v.name + " " + v.help + "\n")
+	buf.WriteString("# TYPE " + v.name + " " + v.typ + "\n")
+}
+
+//调用时持有mu，按标签值排序
+func (v *metricVec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *metricVec) writeSample(buf *bytes.Buffer, name string, values []string, extraKey, extraValue string, value float64) {
+	buf.WriteString(name)
+	if len(v.labels) > 0 || len(extraKey) > 0 {
+		buf.WriteByte('{')
+		for i, label := range v.labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if len(extraKey) > 0 {
+			if len(v.labels) > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(extraKey + `="` + extraValue + `"`)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatMetricValue(value))
+	buf.WriteByte('\n')
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type metricValue struct {
+	labels []string
+	value  float64
+}
+
+//只增的计数
+type Counter struct {
+	metricVec
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newMetricVec(name, help, "counter", labels)}
+	registerMetric(c)
+	return c
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.get(labelValues, func(labels []string) interface{} {
+		return &metricValue{labels: labels}
+	}).(*metricValue)
+	s.value += delta
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+//设置为外部维护的累计值，例：sql.DBStats.WaitCount，在OnMetricsCollect中调用
+func (c *Counter) Set(value float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.get(labelValues, func(labels []string) interface{} {
+		return &metricValue{labels: labels}
+	}).(*metricValue)
+	s.value = value
+}
+
+func (c *Counter) write(buf *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(buf)
+	for _, k := range c.sortedKeys() {
+		s := c.series[k].(*metricValue)
+		c.writeSample(buf, c.name, s.labels, "", "", s.value)
+	}
+}
+
+//即时值
+type Gauge struct {
+	metricVec
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newMetricVec(name, help, "gauge", labels)}
+	registerMetric(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.get(labelValues, func(labels []string) interface{} {
+		return &metricValue{labels: labels}
+	}).(*metricValue)
+	s.value = value
+}
+
+//删除不再存在的序列，例：已关闭的连接池
+func (g *Gauge) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.series = map[string]interface{}{}
+}
+
+func (g *Gauge) write(buf *bytes.Buffer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(buf)
+	for _, k := range g.sortedKeys() {
+		s := g.series[k].(*metricValue)
+		g.writeSample(buf, g.name, s.labels, "", "", s.value)
+	}
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 //各分桶的累计数
+	count  uint64
+	sum    float64
+}
+
+//分布，例：耗时
+type Histogram struct {
+	metricVec
+	buckets []float64
+}
+
+//buckets为空时使用DefaultBuckets
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{metricVec: newMetricVec(name, help, "histogram", labels), buckets: buckets}
+	registerMetric(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues, func(labels []string) interface{} {
+		return &histogramValue{labels: labels, counts: make([]uint64, len(h.buckets))}
+	}).(*histogramValue)
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+//按秒记录耗时
+func (h *Histogram) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+func (h *Histogram) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(buf)
+	for _, k := range h.sortedKeys() {
+		s := h.series[k].(*histogramValue)
+		for i, bound := range h.buckets {
+			h.writeSample(buf, h.name+"_bucket", s.labels, "le", formatMetricValue(bound), float64(s.counts[i]))
+		}
+		h.writeSample(buf, h.name+"_bucket", s.labels, "le", "+Inf", float64(s.count))
+		h.writeSample(buf, h.name+"_sum", s.labels, "", "", s.sum)
+		h.writeSample(buf, h.name+"_count", s.labels, "", "", float64(s.count))
+	}
+}
+
+//框架内置的请求指标
+var (
+	requestTotal    = NewCounter("gomvc_requests_total", "Requests by route, method and errno.", "route", "method", "errno")
+	requestDuration = NewHistogram("gomvc_request_duration_seconds", "Request latency by route, method and errno.", nil, "route", "method", "errno")
+)
+
+//记录请求指标，route为注册的路由path，未经路由的请求记为空
+func (c *Context) ObserveRequest(errno string, latency time.Duration) {
+	route, method := c.GetString(conf.API_ROUTE), c.Request.Method
+	requestTotal.Inc(route, method, errno)
+	requestDuration.ObserveDuration(latency, route, method, errno)
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	c := NewCounter("test_calls_total", "Calls.", "name")
+	c.Inc("a")
+	c.Add(2, "a")
+	c.Inc(`b"x`)
+	waits := NewCounter("test_waits_total", "Waits.")
+	g := NewGauge("test_depth", "Depth.")
+	OnMetricsCollect(func() {
+		g.Set(7)
+		waits.Set(5)
+	})
+	h := NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "name")
+	h.ObserveDuration(50*time.Millisecond, "a")
+	h.Observe(0.5, "a")
+	h.Observe(3, "a")
+
+	r := NewRouter()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", METRICS_PATH, nil))
+	assert.Equal(t, w.Code, 200)
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE test_calls_total counter",
+		`test_calls_total{name="a"} 3`,
+		`test_calls_total{name="b\"x"} 1`,
+		"test_depth 7",
+		"# TYPE test_waits_total counter",
+		"test_waits_total 5",
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{name="a",le="0.1"} 1`,
+		`test_latency_seconds_bucket{name="a",le="1"} 2`,
+		`test_latency_seconds_bucket{name="a",le="+Inf"} 3`,
+		`test_latency_seconds_sum{name="a"} 3.55`,
+		`test_latency_seconds_count{name="a"} 3`,
+		"# TYPE gomvc_requests_total counter",
+	} {
+		assert.Assert(t, strings.Contains(body, line+"\n"), line)
+	}
+}
+
+func TestTaskPoolMetrics(t *testing.T) {
+	block := make(chan struct{})
+	pool := (&TaskPool{Size: 1, Name: "test", Cb: func(param interface{}) (interface{}, error) {
+		<-block
+		return nil, nil
+	}}).Init()
+	pool.Process(1)
+	pool.Process(2)
+
+	var buf strings.Builder
+	assert.NilError(t, WriteMetrics(&buf))
+	assert.Assert(t, strings.Contains(buf.String(), `gomvc_taskpool_active{pool="test"} 1`+"\n"))
+	close(block)
+	assert.NilError(t, pool.Join())
+	buf.Reset()
+	assert.NilError(t, WriteMetrics(&buf))
+	assert.Assert(t, !strings.Contains(buf.String(), `gomvc_taskpool_active{pool="test"}`))
+}
@@ -0,0 +1,16 @@
+package utils
+
+//api中间件，在ApiCb前后执行，调用next继续执行后续中间件和ApiCb；
+//不调用next直接返回错误即中断请求，错误按api失败返回，例：apperr.New(ERROR_NO_AUTH)
+type Middleware func(c *Context, next ApiCb) error
+
+//按mws的顺序包装cb，mws[0]最先执行
+func Chain(cb ApiCb, mws ...Middleware) ApiCb {
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw, next := mws[i], cb
+		cb = func(c *Context) error {
+			return mw(c, next)
+		}
+	}
+	return cb
+}
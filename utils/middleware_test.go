@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gotest.tools/assert"
+)
+
+func traceMiddleware(trace *[]string, name string) Middleware {
+	return func(c *Context, next ApiCb) error {
+		*trace = append(*trace, name)
+		err := next(c)
+		*trace = append(*trace, "/"+name)
+		return err
+	}
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	cb := Chain(func(c *Context) error {
+		trace = append(trace, "cb")
+		return nil
+	}, traceMiddleware(&trace, "a"), traceMiddleware(&trace, "b"))
+	assert.NilError(t, cb(nil))
+	assert.DeepEqual(t, trace, []string{"a", "b", "cb", "/b", "/a"})
+
+	//不调用next中断请求
+	trace = nil
+	deny := errors.New("denied")
+	cb = Chain(func(c *Context) error {
+		trace = append(trace, "cb")
+		return nil
+	}, traceMiddleware(&trace, "a"), func(c *Context, next ApiCb) error {
+		return deny
+	})
+	assert.Equal(t, cb(nil), deny)
+	assert.DeepEqual(t, trace, []string{"a", "/a"})
+}
+
+type testActor struct{}
+
+func (a *testActor) New() ApiActor {
+	return &testActor{}
+}
+
+func (a *testActor) Execute(c *gin.Context, cb ApiCb) {
+	if err := cb(&Context{Context: c, Logger: NewLogger()}); err != nil {
+		c.String(403, err.Error())
+		return
+	}
+	c.String(200, "ok")
+}
+
+func TestRouteGroup(t *testing.T) {
+	var trace []string
+	r := NewRouter()
+	api := r.Group("/mw", traceMiddleware(&trace, "group"))
+	r.Use(traceMiddleware(&trace, "global"))
+	v1 := api.Group("v1/", traceMiddleware(&trace, "v1"))
+	v1.AddRoute("GET", "/ping", &testActor{}, func(c *Context) error {
+		trace = append(trace, "cb")
+		return nil
+	}, WithMiddleware(traceMiddleware(&trace, "route")))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/mw/v1/ping", nil))
+	assert.Equal(t, w.Code, 200)
+	assert.DeepEqual(t, trace, []string{"global", "group", "v1", "route", "cb", "/route", "/v1", "/group", "/global"})
+}
@@ -1,12 +1,21 @@
 package utils
 
 import (
-	"errors"
 	"strings"
+	"time"
 
+	"github.com/neil-peng/gomvc/apperr"
 	"github.com/neil-peng/gomvc/conf"
 )
 
+const (
+	NAME_SERVICE_IP   = "ip"
+	NAME_SERVICE_FILE = "file"
+	NAME_SERVICE_DNS  = "dns"
+
+	defaultNameServiceRefreshMs = 5000
+)
+
 type NameService interface {
 	GetServer(service string) (ip string, port string, err error)
 }
@@ -15,11 +24,63 @@ type IpNameService struct{}
 
 var IpServer IpNameService
 
+var defaultNameService NameService = &IpServer
+
+//InitNameService创建的注册中心，重新初始化时关闭
+var initedRegistry interface{ Close() error }
+
+//按conf中NAME_SERVICE创建名字服务并设为默认，请求上下文默认使用该名字服务
+func InitNameService() NameService {
+	refreshMs := conf.ApiConf.NAME_SERVICE_REFRESH_MS
+	if refreshMs <= 0 {
+		refreshMs = defaultNameServiceRefreshMs
+	}
+	refresh := time.Duration(refreshMs) * time.Millisecond
+
+	if initedRegistry != nil {
+		initedRegistry.Close()
+		initedRegistry = nil
+	}
+	switch conf.ApiConf.NAME_SERVICE {
+	case NAME_SERVICE_FILE:
+		registry, err := NewFileRegistry(conf.ApiConf.NAME_SERVICE_FILE, refresh)
+		if err != nil {
+			panic(err)
+		}
+		initedRegistry = registry
+		SetDefaultNameService(registry)
+	case NAME_SERVICE_DNS:
+		registry := NewDnsRegistry(refresh)
+		initedRegistry = registry
+		SetDefaultNameService(registry)
+	default:
+		SetDefaultNameService(&IpServer)
+	}
+
+	balancer := NewBalancer(defaultNameService, conf.ApiConf.NAME_SERVICE_BALANCE)
+	if conf.ApiConf.NAME_SERVICE_MAX_FAILS > 0 {
+		balancer.MaxFails = conf.ApiConf.NAME_SERVICE_MAX_FAILS
+	}
+	if conf.ApiConf.NAME_SERVICE_EJECT_MS > 0 {
+		balancer.EjectDuration = time.Duration(conf.ApiConf.NAME_SERVICE_EJECT_MS) * time.Millisecond
+	}
+	SetDefaultNameService(balancer)
+	return defaultNameService
+}
+
+func SetDefaultNameService(ns NameService) {
+	defaultNameService = ns
+}
+
+func DefaultNameService() NameService {
+	return defaultNameService
+}
+
 //example: (&IpNameService{}).GetServer("127.0.0.1:3600")
 func (i *IpNameService) GetServer(ipAndPort string) (string, string, error) {
 	addrs := strings.Split(ipAndPort, ":")
 	if len(addrs) == 2 {
 		return addrs[0], addrs[1], nil
 	}
-	return "", "", errors.New(conf.ERROR_NAMESERVICE_ERROR)
+	return "", "", apperr.New(conf.ERROR_NAMESERVICE_ERROR)
 }
@@ -0,0 +1,392 @@
+package utils
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/neil-peng/gomvc/apperr"
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//服务实例
+type Instance struct {
+	Ip     string
+	Port   string
+	Weight int
+}
+
+func (i *Instance) Addr() string {
+	return net.JoinHostPort(i.Ip, i.Port)
+}
+
+type WatchFunc func(service string, instances []*Instance)
+
+//可监听实例变更的名字服务，db、redis连接池据此在实例变更后重建连接
+type Registry interface {
+	NameService
+	GetInstances(service string) ([]*Instance, error)
+	//实例变更时回调cb，返回取消监听的函数
+	Watch(service string, cb WatchFunc) (cancel func())
+}
+
+//按权重随机选择实例，权重<=0按1处理
+func PickInstance(instances []*Instance) *Instance {
+	if len(instances) == 0 {
+		return nil
+	}
+	total := 0
+	for _, ins := range instances {
+		total += instanceWeight(ins)
+	}
+	n := rand.Intn(total)
+	for _, ins := range instances {
+		if n < instanceWeight(ins) {
+			return ins
+		}
+		n -= instanceWeight(ins)
+	}
+	return instances[len(instances)-1]
+}
+
+func instanceWeight(ins *Instance) int {
+	if ins.Weight <= 0 {
+		return 1
+	}
+	return ins.Weight
+}
+
+func instancesKey(instances []*Instance) string {
+	keys := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		keys = append(keys, ins.Addr()+"/"+strconv.Itoa(ins.Weight))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func getServer(instances []*Instance) (string, string, error) {
+	ins := PickInstance(instances)
+	if ins == nil {
+		return "", "", apperr.New(conf.ERROR_NAMESERVICE_ERROR)
+	}
+	return ins.Ip, ins.Port, nil
+}
+
+//各Registry共用的监听者管理
+type watchers struct {
+	mu   sync.Mutex
+	id   int
+	cbs  map[string]map[int]WatchFunc
+	last map[string]string
+}
+
+func (w *watchers) Watch(service string, cb WatchFunc) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cbs == nil {
+		w.cbs = make(map[string]map[int]WatchFunc)
+	}
+	if w.cbs[service] == nil {
+		w.cbs[service] = make(map[int]WatchFunc)
+	}
+	w.id++
+	id := w.id
+	w.cbs[service][id] = cb
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.cbs[service], id)
+	}
+}
+
+//实例有变化时通知监听者，首次记录不通知
+func (w *watchers) notify(service string, instances []*Instance) {
+	w.mu.Lock()
+	if w.last == nil {
+		w.last = make(map[string]string)
+	}
+	key := instancesKey(instances)
+	last, ok := w.last[service]
+	w.last[service] = key
+	var cbs []WatchFunc
+	if ok && last != key {
+		for _, cb := range w.cbs[service] {
+			cbs = append(cbs, cb)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, cb := range cbs {
+		Notice("nameservice changed, service:%s, instances:%s", service, key)
+		cb(service, instances)
+	}
+}
+
+//静态文件注册中心，文件修改后自动重新加载，格式：
+//[[service]]
+//    name = "mvc-mysql"
+//    [[service.instance]]
+//        addr   = "127.0.0.1:3306"
+//        weight = 10
+type FileRegistry struct {
+	watchers
+	path     string
+	modTime  time.Time
+	services map[string][]*Instance
+	rw       sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type registryFile struct {
+	Service []struct {
+		Name     string
+		Instance []struct {
+			Addr   string
+			Weight int
+		}
+	}
+}
+
+func NewFileRegistry(path string, refresh time.Duration) (*FileRegistry, error) {
+	f := &FileRegistry{path: path, stop: make(chan struct{})}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	go f.reload(refresh)
+	return f, nil
+}
+
+func (f *FileRegistry) reload(refresh time.Duration) {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+		if err := f.load(); err != nil {
+			Warn("reload nameservice file fail, path:%s, err:%v", f.path, err)
+		}
+	}
+}
+
+//停止定时重新加载，可重复调用
+func (f *FileRegistry) Close() error {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+	return nil
+}
+
+func (f *FileRegistry) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	var file registryFile
+	if _, err := toml.DecodeFile(f.path, &file); err != nil {
+		return err
+	}
+	services := make(map[string][]*Instance)
+	for _, service := range file.Service {
+		for _, item := range service.Instance {
+			ip, port, err := net.SplitHostPort(item.Addr)
+			if err != nil {
+				return err
+			}
+			services[service.Name] = append(services[service.Name], &Instance{Ip: ip, Port: port, Weight: item.Weight})
+		}
+	}
+
+	f.rw.Lock()
+	oldServices := f.services
+	f.services = services
+	f.modTime = info.ModTime()
+	f.rw.Unlock()
+	for service, instances := range services {
+		f.notify(service, instances)
+	}
+	for service := range oldServices {
+		if _, ok := services[service]; !ok {
+			f.notify(service, nil)
+		}
+	}
+	return nil
+}
+
+func (f *FileRegistry) GetInstances(service string) ([]*Instance, error) {
+	f.rw.RLock()
+	defer f.rw.RUnlock()
+	instances, ok := f.services[service]
+	if !ok {
+		return nil, apperr.New(conf.ERROR_NAMESERVICE_ERROR)
+	}
+	return instances, nil
+}
+
+func (f *FileRegistry) GetServer(service string) (string, string, error) {
+	instances, err := f.GetInstances(service)
+	if err != nil {
+		return "", "", err
+	}
+	return getServer(instances)
+}
+
+//DNS SRV解析，service为完整的SRV名，例：_mysql._tcp.db.example.com；
+//只使用优先级最高(priority最小)的记录，解析过的服务定时重新解析，解析失败时保留原有结果
+type DnsRegistry struct {
+	watchers
+	refresh  time.Duration
+	services map[string][]*Instance
+	rw       sync.RWMutex
+	once     sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+	lookup   func(service, proto, name string) (string, []*net.SRV, error) //测试时替换
+}
+
+func NewDnsRegistry(refresh time.Duration) *DnsRegistry {
+	return &DnsRegistry{
+		refresh:  refresh,
+		services: make(map[string][]*Instance),
+		stop:     make(chan struct{}),
+		lookup:   net.LookupSRV,
+	}
+}
+
+func (d *DnsRegistry) resolve(service string) ([]*Instance, error) {
+	_, srvs, err := d.lookup("", "", service)
+	if err != nil || len(srvs) == 0 {
+		Warn("lookup srv fail, service:%s, err:%v", service, err)
+		return nil, apperr.New(conf.ERROR_NAMESERVICE_ERROR)
+	}
+	var instances []*Instance
+	for _, srv := range srvs {
+		//LookupSRV已按priority排序
+		if srv.Priority != srvs[0].Priority {
+			break
+		}
+		instances = append(instances, &Instance{
+			Ip:     strings.TrimSuffix(srv.Target, "."),
+			Port:   strconv.Itoa(int(srv.Port)),
+			Weight: int(srv.Weight),
+		})
+	}
+	d.rw.Lock()
+	d.services[service] = instances
+	d.rw.Unlock()
+	d.notify(service, instances)
+	return instances, nil
+}
+
+func (d *DnsRegistry) GetInstances(service string) ([]*Instance, error) {
+	d.rw.RLock()
+	instances, ok := d.services[service]
+	d.rw.RUnlock()
+	if ok {
+		return instances, nil
+	}
+	d.once.Do(func() {
+		go d.reload()
+	})
+	return d.resolve(service)
+}
+
+func (d *DnsRegistry) GetServer(service string) (string, string, error) {
+	instances, err := d.GetInstances(service)
+	if err != nil {
+		return "", "", err
+	}
+	return getServer(instances)
+}
+
+func (d *DnsRegistry) Watch(service string, cb WatchFunc) func() {
+	d.GetInstances(service)
+	return d.watchers.Watch(service, cb)
+}
+
+func (d *DnsRegistry) reload() {
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, service := range d.cached() {
+			d.resolve(service)
+		}
+	}
+}
+
+func (d *DnsRegistry) cached() []string {
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	services := make([]string, 0, len(d.services))
+	for service := range d.services {
+		services = append(services, service)
+	}
+	return services
+}
+
+//停止定时重新解析，可重复调用
+func (d *DnsRegistry) Close() error {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	return nil
+}
+
+//内存注册中心，用于测试或本地开发
+type FakeRegistry struct {
+	watchers
+	services map[string][]*Instance
+	rw       sync.RWMutex
+}
+
+func NewFakeRegistry() *FakeRegistry {
+	return &FakeRegistry{services: make(map[string][]*Instance)}
+}
+
+//设置服务实例，变更会通知监听者
+func (f *FakeRegistry) Set(service string, instances ...*Instance) {
+	f.rw.Lock()
+	_, existed := f.services[service]
+	f.services[service] = instances
+	f.rw.Unlock()
+	if !existed {
+		f.notify(service, nil)
+	}
+	f.notify(service, instances)
+}
+
+func (f *FakeRegistry) GetInstances(service string) ([]*Instance, error) {
+	f.rw.RLock()
+	defer f.rw.RUnlock()
+	instances, ok := f.services[service]
+	if !ok {
+		return nil, apperr.New(conf.ERROR_NAMESERVICE_ERROR)
+	}
+	return instances, nil
+}
+
+func (f *FakeRegistry) GetServer(service string) (string, string, error) {
+	instances, err := f.GetInstances(service)
+	if err != nil {
+		return "", "", err
+	}
+	return getServer(instances)
+}
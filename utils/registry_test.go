@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestFakeRegistryWatch(t *testing.T) {
+	f := NewFakeRegistry()
+	var changed [][]*Instance
+	cancel := f.Watch("mvc-redis", func(service string, instances []*Instance) {
+		changed = append(changed, instances)
+	})
+
+	f.Set("mvc-redis", &Instance{Ip: "127.0.0.1", Port: "6379"})
+	ip, port, err := f.GetServer("mvc-redis")
+	assert.NilError(t, err)
+	assert.Equal(t, ip+":"+port, "127.0.0.1:6379")
+	assert.Equal(t, len(changed), 1)
+
+	//实例未变化不通知
+	f.Set("mvc-redis", &Instance{Ip: "127.0.0.1", Port: "6379"})
+	assert.Equal(t, len(changed), 1)
+
+	f.Set("mvc-redis", &Instance{Ip: "127.0.0.2", Port: "6379"})
+	assert.Equal(t, len(changed), 2)
+
+	cancel()
+	f.Set("mvc-redis", &Instance{Ip: "127.0.0.3", Port: "6379"})
+	assert.Equal(t, len(changed), 2)
+
+	_, _, err = f.GetServer("unknown")
+	assert.Equal(t, true, err != nil)
+}
+
+func TestFileRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nameservice.toml")
+	write := func(addr string) {
+		content := "[[service]]\nname = \"mvc-mysql\"\n[[service.instance]]\naddr = \"" + addr + "\"\nweight = 10\n"
+		assert.NilError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	write("127.0.0.1:3306")
+	f, err := NewFileRegistry(path, time.Hour)
+	assert.NilError(t, err)
+	changed := make(chan []*Instance, 1)
+	f.Watch("mvc-mysql", func(service string, instances []*Instance) {
+		changed <- instances
+	})
+	ip, port, err := f.GetServer("mvc-mysql")
+	assert.NilError(t, err)
+	assert.Equal(t, ip+":"+port, "127.0.0.1:3306")
+
+	write("127.0.0.2:3306")
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	assert.NilError(t, f.load())
+	instances := <-changed
+	assert.Equal(t, instances[0].Addr(), "127.0.0.2:3306")
+	assert.Equal(t, instances[0].Weight, 10)
+}
+
+func TestFileRegistryClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nameservice.toml")
+	write := func(addr string, mod time.Time) {
+		content := "[[service]]\nname = \"mvc-mysql\"\n[[service.instance]]\naddr = \"" + addr + "\"\n"
+		assert.NilError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		assert.NilError(t, os.Chtimes(path, mod, mod))
+	}
+
+	write("127.0.0.1:3306", time.Now())
+	f, err := NewFileRegistry(path, time.Millisecond)
+	assert.NilError(t, err)
+	assert.NilError(t, f.Close())
+	assert.NilError(t, f.Close())
+
+	//关闭后不再重新加载
+	time.Sleep(5 * time.Millisecond)
+	write("127.0.0.2:3306", time.Now().Add(time.Second))
+	time.Sleep(20 * time.Millisecond)
+	ip, port, err := f.GetServer("mvc-mysql")
+	assert.NilError(t, err)
+	assert.Equal(t, ip+":"+port, "127.0.0.1:3306")
+}
+
+func TestDnsRegistryRefresh(t *testing.T) {
+	var mu sync.Mutex
+	target := "a.example.com."
+	d := NewDnsRegistry(time.Millisecond)
+	defer d.Close()
+	d.lookup = func(service, proto, name string) (string, []*net.SRV, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return "", []*net.SRV{{Target: target, Port: 3306, Weight: 1}}, nil
+	}
+	ip, _, err := d.GetServer("_mysql._tcp.db.example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, ip, "a.example.com")
+
+	//未监听的服务也定时重新解析
+	mu.Lock()
+	target = "b.example.com."
+	mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for ip != "b.example.com" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		ip, _, err = d.GetServer("_mysql._tcp.db.example.com")
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, ip, "b.example.com")
+}
@@ -1,7 +1,15 @@
 package utils
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/neil-peng/gomvc/conf"
 )
 
 type ApiCb func(context *Context) error
@@ -11,14 +19,240 @@ type ApiActor interface {
 	Execute(*gin.Context, ApiCb)
 }
 
-var g = gin.Default()
+//路由级配置
+type RouteConf struct {
+	Timeout time.Duration //处理时限，默认取conf中ROUTE_TIMEOUT_MS/API_TIMEOUT_MS
+	Encoder string        //响应编码器，见ENCODER_*，为空时按Accept协商
+
+	Middlewares []Middleware //路由中间件，在全局和分组中间件之后执行
+}
+
+type RouteOption func(*RouteConf)
+
+func WithTimeout(timeout time.Duration) RouteOption {
+	return func(rc *RouteConf) {
+		rc.Timeout = timeout
+	}
+}
+
+//固定响应编码，不再按Accept协商
+func WithEncoder(name string) RouteOption {
+	return func(rc *RouteConf) {
+		rc.Encoder = name
+	}
+}
+
+//追加路由中间件
+func WithMiddleware(mws ...Middleware) RouteOption {
+	return func(rc *RouteConf) {
+		rc.Middlewares = append(rc.Middlewares, mws...)
+	}
+}
+
+func newRouteConf(path string, opts ...RouteOption) *RouteConf {
+	timeoutMs := conf.ApiConf.API_TIMEOUT_MS
+	if ms, ok := conf.ApiConf.ROUTE_TIMEOUT_MS[path]; ok {
+		timeoutMs = ms
+	}
+	rc := &RouteConf{
+		Timeout: time.Duration(timeoutMs) * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(rc)
+	}
+	return rc
+}
+
+//已注册的路由，用于启动时输出路由表
+type RouteInfo struct {
+	Method      string
+	Path        string
+	Actor       string
+	Timeout     time.Duration
+	Encoder     string
+	Middlewares int
+}
+
+//路由，根分组的中间件即全局中间件
+type Router struct {
+	*RouteGroup
+	engine   *gin.Engine
+	routes   []RouteInfo
+	listener net.Listener
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+}
+
+func NewRouter() *Router {
+	r := &Router{
+		engine: gin.Default(),
+		stop:   make(chan struct{}),
+	}
+	r.RouteGroup = &RouteGroup{router: r}
+	r.addHealthRoutes()
+	r.addMetricsRoute()
+	return r
+}
+
+var defaultRouter = NewRouter()
+
+//AddRoute、Use、Group、RunServer使用的路由
+func DefaultRouter() *Router {
+	return defaultRouter
+}
+
+func (r *Router) Engine() *gin.Engine {
+	return r.engine
+}
+
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.engine.ServeHTTP(w, req)
+}
+
+func (r *Router) Routes() []RouteInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RouteInfo{}, r.routes...)
+}
+
+//输出路由表，每个路由一行
+func (r *Router) DumpRoutes() {
+	for _, route := range r.Routes() {
+		encoder := route.Encoder
+		if len(encoder) == 0 {
+			encoder = "accept"
+		}
+		Notice("route:%-7s %s actor:%s timeout:%v encoder:%s middlewares:%d",
+			route.Method, route.Path, route.Actor, route.Timeout, encoder, route.Middlewares)
+	}
+}
 
-func AddRoute(method string, path string, apiAct ApiActor, cb ApiCb) {
-	g.Handle(method, path, func(c *gin.Context) {
+func (r *Router) handle(method string, path string, apiAct ApiActor, cb ApiCb, mws []Middleware, opts []RouteOption) {
+	if apiAct == nil {
+		panic(fmt.Sprintf("no ApiActor for route %s %s", method, path))
+	}
+	rc := newRouteConf(path, opts...)
+	mws = append(mws, rc.Middlewares...)
+	cb = Chain(cb, mws...)
+	r.engine.Handle(method, path, func(c *gin.Context) {
+		c.Set(conf.API_TIMEOUT, rc.Timeout)
+		c.Set(conf.API_ROUTE, path)
+		if len(rc.Encoder) > 0 {
+			c.Set(conf.API_ENCODER, rc.Encoder)
+		}
 		apiAct.New().Execute(c, cb)
 	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, RouteInfo{
+		Method:      method,
+		Path:        path,
+		Actor:       fmt.Sprintf("%T", apiAct),
+		Timeout:     rc.Timeout,
+		Encoder:     rc.Encoder,
+		Middlewares: len(mws),
+	})
+}
+
+//路由分组，组内路由共用path前缀、ApiActor和中间件；
+//中间件执行顺序：全局(根分组)、外层分组、内层分组、路由(WithMiddleware)，同一层按注册顺序
+type RouteGroup struct {
+	router      *Router
+	parent      *RouteGroup
+	prefix      string
+	actor       ApiActor
+	middlewares []Middleware
+}
+
+//子分组，继承当前分组的前缀、ApiActor和中间件
+func (rg *RouteGroup) Group(prefix string, mws ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:      rg.router,
+		parent:      rg,
+		prefix:      joinPath(rg.prefix, prefix),
+		middlewares: mws,
+	}
+}
+
+//只对之后添加的路由生效
+func (rg *RouteGroup) Use(mws ...Middleware) {
+	rg.middlewares = append(rg.middlewares, mws...)
+}
+
+//设置分组内路由的ApiActor，未设置时取上层分组的
+func (rg *RouteGroup) SetActor(actor ApiActor) *RouteGroup {
+	rg.actor = actor
+	return rg
+}
+
+func (rg *RouteGroup) getActor() ApiActor {
+	for g := rg; g != nil; g = g.parent {
+		if g.actor != nil {
+			return g.actor
+		}
+	}
+	return nil
+}
+
+func (rg *RouteGroup) getMiddlewares() []Middleware {
+	if rg.parent == nil {
+		return append([]Middleware{}, rg.middlewares...)
+	}
+	return append(rg.parent.getMiddlewares(), rg.middlewares...)
+}
+
+//使用指定的ApiActor添加路由，path支持gin的:name、*name参数
+func (rg *RouteGroup) AddRoute(method string, path string, apiAct ApiActor, cb ApiCb, opts ...RouteOption) {
+	rg.router.handle(method, joinPath(rg.prefix, path), apiAct, cb, rg.getMiddlewares(), opts)
+}
+
+//使用分组的ApiActor添加路由
+func (rg *RouteGroup) Handle(method string, path string, cb ApiCb, opts ...RouteOption) {
+	rg.AddRoute(method, path, rg.getActor(), cb, opts...)
+}
+
+func (rg *RouteGroup) GET(path string, cb ApiCb, opts ...RouteOption) {
+	rg.Handle(http.MethodGet, path, cb, opts...)
+}
+
+func (rg *RouteGroup) POST(path string, cb ApiCb, opts ...RouteOption) {
+	rg.Handle(http.MethodPost, path, cb, opts...)
+}
+
+func (rg *RouteGroup) PUT(path string, cb ApiCb, opts ...RouteOption) {
+	rg.Handle(http.MethodPut, path, cb, opts...)
+}
+
+func (rg *RouteGroup) DELETE(path string, cb ApiCb, opts ...RouteOption) {
+	rg.Handle(http.MethodDelete, path, cb, opts...)
+}
+
+func joinPath(prefix, path string) string {
+	if len(path) == 0 {
+		return prefix
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+func AddRoute(method string, path string, apiAct ApiActor, cb ApiCb, opts ...RouteOption) {
+	defaultRouter.AddRoute(method, path, apiAct, cb, opts...)
+}
+
+//注册全局中间件，只对之后添加的路由生效
+func Use(mws ...Middleware) {
+	defaultRouter.Use(mws...)
+}
+
+func Group(prefix string, mws ...Middleware) *RouteGroup {
+	return defaultRouter.Group(prefix, mws...)
 }
 
+//阻塞至Shutdown后退出完成
 func RunServer(server string) {
-	Notice("run:%v", g.Run(server))
+	if err := defaultRouter.Run(server); err != nil {
+		Critical("run server fail, err:%v", err)
+		Flush()
+	}
 }
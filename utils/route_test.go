@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	r.SetActor(&testActor{})
+	user := r.Group("/rest/v1").Group("user")
+	user.GET("/:id", func(c *Context) error {
+		id, err := c.ParamInt64("id")
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, id, int64(42))
+		return nil
+	})
+	user.DELETE("/:id", func(c *Context) error {
+		return nil
+	}, WithEncoder(ENCODER_XML))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/rest/v1/user/42", nil))
+	assert.Equal(t, w.Code, 200)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/rest/v1/user/abc", nil))
+	assert.Equal(t, w.Code, 403)
+	assert.Equal(t, w.Body.String(), `10006: strconv.ParseInt: parsing "abc": invalid syntax`)
+
+	//livez、healthz、readyz、metrics之后
+	routes := r.Routes()
+	assert.Equal(t, len(routes), 6)
+	assert.Equal(t, routes[5].Method, "DELETE")
+	assert.Equal(t, routes[5].Path, "/rest/v1/user/:id")
+	assert.Equal(t, routes[5].Actor, "*utils.testActor")
+	assert.Equal(t, routes[5].Encoder, ENCODER_XML)
+}
@@ -0,0 +1,254 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//退出时各资源的关闭顺序，在停止接收请求并等待处理中的请求结束后按从小到大执行
+const (
+	SHUTDOWN_ORDER_TASK  = 10
+	SHUTDOWN_ORDER_DB    = 20
+	SHUTDOWN_ORDER_REDIS = 30
+	SHUTDOWN_ORDER_TRACE = 90
+	SHUTDOWN_ORDER_LOG   = 100
+)
+
+//平滑重启时传给新进程的监听fd和就绪通知fd
+const (
+	LISTEN_FD_ENV = "GOMVC_LISTEN_FD"
+	READY_FD_ENV  = "GOMVC_READY_FD"
+)
+
+//平滑重启等待新进程就绪的时限
+const restartReadyTimeout = 30 * time.Second
+
+type shutdownHook struct {
+	name  string
+	order int
+	fn    func(ctx context.Context) error
+}
+
+var (
+	shutdownHooks   []shutdownHook
+	shutdownHooksMu sync.Mutex
+)
+
+func init() {
+	OnShutdown("taskpool", SHUTDOWN_ORDER_TASK, waitTaskPools)
+	OnShutdown("log", SHUTDOWN_ORDER_LOG, func(ctx context.Context) error {
+		Flush()
+		return nil
+	})
+}
+
+//注册退出时执行的关闭函数，order相同时按注册顺序；ctx在SHUTDOWN_TIMEOUT_MS后超时
+func OnShutdown(name string, order int, fn func(ctx context.Context) error) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, shutdownHook{name: name, order: order, fn: fn})
+}
+
+func runShutdownHooks(ctx context.Context) {
+	shutdownHooksMu.Lock()
+	hooks := append([]shutdownHook{}, shutdownHooks...)
+	shutdownHooksMu.Unlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].order < hooks[j].order
+	})
+	for _, hook := range hooks {
+		start := time.Now()
+		if err := hook.fn(ctx); err != nil {
+			Warn("shutdown %s fail, cost:%v, err:%v", hook.name, time.Since(start), err)
+		} else {
+			Notice("shutdown %s, cost:%v", hook.name, time.Since(start))
+		}
+	}
+}
+
+//启动服务，阻塞至Shutdown后处理中的请求结束、关闭函数执行完
+func (r *Router) Run(addr string) error {
+	r.DumpRoutes()
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.listener = ln
+	r.mu.Unlock()
+
+	server := &http.Server{Handler: r.engine}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ln)
+	}()
+	Notice("run server, addr:%s, pid:%d", ln.Addr(), os.Getpid())
+	notifyReady()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-r.stop:
+	}
+
+	//readyz已返回503，等待负载均衡摘除后再停止接收请求
+	if delay := conf.ApiConf.SHUTDOWN_READY_DELAY_MS; delay > 0 {
+		Notice("wait for readiness probe, delay:%dms", delay)
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+	}
+	ctx := context.Background()
+	timeout := time.Duration(conf.ApiConf.SHUTDOWN_TIMEOUT_MS) * time.Millisecond
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	if err = server.Shutdown(ctx); err != nil {
+		Warn("drain requests fail, cost:%v, err:%v", time.Since(start), err)
+	} else {
+		Notice("drain requests, cost:%v", time.Since(start))
+	}
+	runShutdownHooks(ctx)
+	return err
+}
+
+//停止接收新请求，Run在处理中的请求结束后返回；可重复调用
+func (r *Router) Shutdown() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+//Shutdown后为true
+func (r *Router) draining() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//平滑重启：启动新进程并传递监听socket，新进程开始接收请求后本进程按Shutdown退出；
+//新进程未就绪就退出或超时时本进程继续服务并返回错误
+func (r *Router) Restart() error {
+	r.mu.Lock()
+	ln := r.listener
+	r.mu.Unlock()
+	tcpLn, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("restart without running tcp listener")
+	}
+	f, err := tcpLn.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	if err := startChild(cmd, f, restartReadyTimeout); err != nil {
+		return err
+	}
+	r.Shutdown()
+	return nil
+}
+
+//启动新进程并等待其就绪：ExtraFiles[0]在新进程中为fd 3，即监听socket；
+//ExtraFiles[1]为fd 4，即就绪通知pipe的写端，新进程退出时本进程读到EOF
+func startChild(cmd *exec.Cmd, ln *os.File, timeout time.Duration) error {
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	cmd.Env = append(cmd.Env, LISTEN_FD_ENV+"=3", READY_FD_ENV+"=4")
+	cmd.ExtraFiles = []*os.File{ln, readyW}
+	err = cmd.Start()
+	//只保留新进程持有的写端
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+	Notice("restart, new pid:%d, wait for ready", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err == nil {
+			Notice("new process ready, pid:%d", cmd.Process.Pid)
+			return nil
+		}
+		return fmt.Errorf("new process exited before ready, pid:%d, err:%v", cmd.Process.Pid, cmd.Wait())
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return fmt.Errorf("new process not ready in %v, pid:%d, killed", timeout, cmd.Process.Pid)
+	}
+}
+
+//平滑重启的新进程开始接收请求后通知父进程
+func notifyReady() {
+	fd := os.Getenv(READY_FD_ENV)
+	if len(fd) == 0 {
+		return
+	}
+	os.Unsetenv(READY_FD_ENV)
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		Warn("invalid %s:%s", READY_FD_ENV, fd)
+		return
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		Warn("notify ready fail, err:%v", err)
+	}
+}
+
+//优先使用父进程传递的监听socket
+func listen(addr string) (net.Listener, error) {
+	fd := os.Getenv(LISTEN_FD_ENV)
+	if len(fd) == 0 {
+		return net.Listen("tcp", addr)
+	}
+	os.Unsetenv(LISTEN_FD_ENV)
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	Notice("inherit listener, fd:%d", n)
+	return net.FileListener(f)
+}
+
+func Shutdown() {
+	defaultRouter.Shutdown()
+}
+
+func Restart() error {
+	return defaultRouter.Restart()
+}
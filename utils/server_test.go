@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	var trace []string
+	OnShutdown("second", SHUTDOWN_ORDER_REDIS, func(ctx context.Context) error {
+		trace = append(trace, "second")
+		return nil
+	})
+	OnShutdown("first", SHUTDOWN_ORDER_TASK, func(ctx context.Context) error {
+		trace = append(trace, "first")
+		return nil
+	})
+
+	r := NewRouter()
+	started := make(chan struct{})
+	r.AddRoute("GET", "/slow", &testActor{}, func(c *Context) error {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run("127.0.0.1:0")
+	}()
+
+	var addr string
+	for addr == "" {
+		time.Sleep(time.Millisecond)
+		r.mu.Lock()
+		if r.listener != nil {
+			addr = r.listener.Addr().String()
+		}
+		r.mu.Unlock()
+	}
+
+	result := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		result <- string(body)
+	}()
+	<-started
+	r.Shutdown()
+	r.Shutdown()
+
+	//处理中的请求正常返回
+	assert.Equal(t, <-result, "ok")
+	assert.NilError(t, <-done)
+	assert.DeepEqual(t, trace, []string{"first", "second"})
+
+	_, err := http.Get("http://" + addr + "/slow")
+	assert.Assert(t, err != nil)
+}
+
+//平滑重启的新进程，由TestStartChild以GOMVC_TEST_RESTART_CHILD启动
+func TestRestartChild(t *testing.T) {
+	switch os.Getenv("GOMVC_TEST_RESTART_CHILD") {
+	case "ready":
+		notifyReady()
+		time.Sleep(100 * time.Millisecond)
+	case "fail":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func TestStartChild(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	assert.NilError(t, err)
+	defer f.Close()
+
+	newChild := func(mode string) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestRestartChild$")
+		cmd.Env = append(os.Environ(), "GOMVC_TEST_RESTART_CHILD="+mode)
+		return cmd
+	}
+	cmd := newChild("ready")
+	assert.NilError(t, startChild(cmd, f, 10*time.Second))
+	cmd.Wait()
+
+	//新进程未就绪就退出时返回错误，本进程继续服务
+	err = startChild(newChild("fail"), f, 10*time.Second)
+	assert.ErrorContains(t, err, "exited before ready")
+
+	err = startChild(newChild("hang"), f, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "not ready")
+}
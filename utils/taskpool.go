@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -9,6 +10,64 @@ import (
 
 var ErrPoolTimeOut = errors.New("add to pool timeout")
 
+//未Join的任务池，退出时等待其中已投递的任务，输出指标时统计队列长度
+var (
+	activeTaskPools = map[*TaskPool]struct{}{}
+	taskPoolsMu     sync.Mutex
+)
+
+var (
+	taskPoolActive     = NewGauge("gomvc_taskpool_active", "Task pools not yet joined, by pool name.", "pool")
+	taskPoolQueueDepth = NewGauge("gomvc_taskpool_queue_depth", "Queued tasks of active task pools, by pool name.", "pool")
+)
+
+func init() {
+	OnMetricsCollect(collectTaskPools)
+}
+
+//同名的任务池合并统计
+func collectTaskPools() {
+	active, depth := map[string]int{}, map[string]int{}
+	taskPoolsMu.Lock()
+	for t := range activeTaskPools {
+		active[t.name()]++
+		depth[t.name()] += len(t.queue)
+	}
+	taskPoolsMu.Unlock()
+
+	taskPoolActive.Reset()
+	taskPoolQueueDepth.Reset()
+	for name, n := range active {
+		taskPoolActive.Set(float64(n), name)
+		taskPoolQueueDepth.Set(float64(depth[name]), name)
+	}
+}
+
+//退出时等待任务池中已投递的任务处理完，空闲但未Join的任务池不等待；ctx超时后不再等待
+func waitTaskPools(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !taskPoolsIdle() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func taskPoolsIdle() bool {
+	taskPoolsMu.Lock()
+	defer taskPoolsMu.Unlock()
+	for t := range activeTaskPools {
+		if !t.idle() {
+			return false
+		}
+	}
+	return true
+}
+
 //任务回调函数
 type FuncCb func(param interface{}) (cbResult interface{}, cbErr error)
 
@@ -27,11 +86,13 @@ type TaskPool struct {
 	Cb            FuncCb  //任务回调
 	Out           FuncOut //汇总任务返回，可选
 	LooseCheck    bool    //单次任务失败是否认为总任务失败，可选，默认严格校验
+	Name          string  //指标中的任务池名，可选，默认default
 
-	id    int64
-	queue chan *QueueItem
-	err   error
-	stop  bool
+	id      int64
+	pending int64 //已投递未处理完的任务数
+	queue   chan *QueueItem
+	err     error
+	stop    bool
 	sync.WaitGroup
 	sync.RWMutex
 }
@@ -41,38 +102,60 @@ func (t *TaskPool) Init() *TaskPool {
 		t.Ctx.CloseCostGather()
 	}
 	t.queue = make(chan *QueueItem, t.Size)
+	taskPoolsMu.Lock()
+	activeTaskPools[t] = struct{}{}
+	taskPoolsMu.Unlock()
 	for i := 0; i < t.Size; i++ {
 		t.Add(1)
 		go func() {
 			defer t.Done()
 			for queueMsg := range t.queue {
-				if t.IfStop() {
+				stop := t.handle(queueMsg)
+				atomic.AddInt64(&t.pending, -1)
+				if stop {
 					break
 				}
-				subResult, err := t.Cb(queueMsg.param)
-				if t.Out == nil {
-					if t.LooseCheck {
-						continue
-					}
-					if !t.LooseCheck && err != nil {
-						t.SetStop(err)
-						break
-					}
-				} else {
-					t.Lock()
-					outErr := t.Out(queueMsg.id, subResult, err)
-					t.Unlock()
-					if !t.LooseCheck && outErr != nil {
-						t.SetStop(outErr)
-						break
-					}
-				}
 			}
 		}()
 	}
 	return t
 }
 
+//处理一个任务，返回true时worker退出
+func (t *TaskPool) handle(item *QueueItem) bool {
+	if t.IfStop() {
+		return true
+	}
+	subResult, err := t.Cb(item.param)
+	if t.Out == nil {
+		if !t.LooseCheck && err != nil {
+			t.SetStop(err)
+			return true
+		}
+		return false
+	}
+	t.Lock()
+	outErr := t.Out(item.id, subResult, err)
+	t.Unlock()
+	if !t.LooseCheck && outErr != nil {
+		t.SetStop(outErr)
+		return true
+	}
+	return false
+}
+
+//已停止的任务池剩余任务不再处理
+func (t *TaskPool) idle() bool {
+	return t.IfStop() || atomic.LoadInt64(&t.pending) == 0
+}
+
+func (t *TaskPool) name() string {
+	if len(t.Name) == 0 {
+		return "default"
+	}
+	return t.Name
+}
+
 func (t *TaskPool) IfStop() bool {
 	t.RLock()
 	defer t.RUnlock()
@@ -91,6 +174,7 @@ func (t *TaskPool) Process(param interface{}) error {
 	id := atomic.LoadInt64(&t.id)
 	defer atomic.AddInt64(&t.id, 1)
 
+	atomic.AddInt64(&t.pending, 1)
 	if t.PoolTimeOutMs <= 0 {
 		t.queue <- &QueueItem{id, param}
 		return nil
@@ -99,6 +183,7 @@ func (t *TaskPool) Process(param interface{}) error {
 	select {
 	case t.queue <- &QueueItem{id, param}:
 	case <-time.After(time.Duration(t.PoolTimeOutMs) * time.Millisecond):
+		atomic.AddInt64(&t.pending, -1)
 		t.err = ErrPoolTimeOut
 		return t.err
 	}
@@ -108,6 +193,9 @@ func (t *TaskPool) Process(param interface{}) error {
 func (t *TaskPool) Join() error {
 	close(t.queue)
 	t.Wait()
+	taskPoolsMu.Lock()
+	delete(activeTaskPools, t)
+	taskPoolsMu.Unlock()
 	if t.Ctx != nil {
 		t.Ctx.OpenCostGather()
 	}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"gotest.tools/assert"
@@ -147,3 +148,22 @@ func TestTimeOutCbOutTaskPool(t *testing.T) {
 	assert.Equal(t, true, err != nil)
 	return
 }
+
+func TestWaitTaskPools(t *testing.T) {
+	block := make(chan struct{})
+	p := (&TaskPool{Size: 1, Cb: func(param interface{}) (interface{}, error) {
+		<-block
+		return nil, nil
+	}}).Init()
+	defer p.Join()
+	p.Process(1)
+
+	//有未处理完的任务时等待至超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, waitTaskPools(ctx), context.DeadlineExceeded)
+
+	//任务处理完后未Join的空闲任务池不阻塞退出
+	close(block)
+	assert.NilError(t, waitTaskPools(context.Background()))
+}
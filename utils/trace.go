@@ -0,0 +1,356 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neil-peng/gomvc/conf"
+)
+
+//W3C trace context的请求头
+const (
+	TRACE_PARENT_HEADER = "traceparent"
+	TRACE_STATE_HEADER  = "tracestate"
+)
+
+//span类型，取值同OTLP
+type SpanKind int
+
+const (
+	SPAN_KIND_INTERNAL SpanKind = 1
+	SPAN_KIND_SERVER   SpanKind = 2
+	SPAN_KIND_CLIENT   SpanKind = 3
+)
+
+type TraceId [16]byte
+
+func (t TraceId) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceId) IsValid() bool {
+	return t != TraceId{}
+}
+
+type SpanId [8]byte
+
+func (s SpanId) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanId) IsValid() bool {
+	return s != SpanId{}
+}
+
+//一次调用的耗时区间，同一请求内的span通过ParentId组成调用树；
+//方法均可在nil上调用，未开启trace的Context返回的span为nil
+type Span struct {
+	TraceId  TraceId
+	SpanId   SpanId
+	ParentId SpanId
+	Name     string
+	Kind     SpanKind
+	Start    time.Time
+	Finish   time.Time
+	Attrs    map[string]interface{}
+	Err      string //非空为失败
+
+	sampled    bool
+	traceState string
+	parent     *Span //结束后恢复为Context当前span
+	recorder   *spanRecorder
+	ended      bool
+	mu         sync.Mutex
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attrs == nil {
+		s.Attrs = map[string]interface{}{}
+	}
+	s.Attrs[key] = value
+}
+
+//结束span，err非nil记为失败；重复调用忽略
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.Finish = time.Now()
+	if err != nil {
+		s.Err = err.Error()
+	}
+	s.mu.Unlock()
+	if s.recorder != nil {
+		s.recorder.finish(s)
+	}
+}
+
+func (s *Span) Sampled() bool {
+	return s != nil && s.sampled
+}
+
+//W3C traceparent：version-traceid-spanid-flags
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceId.String() + "-" + s.SpanId.String() + "-" + flags
+}
+
+//写入下游请求头，下游的span以s为父节点
+func (s *Span) Inject(h http.Header) {
+	if s == nil {
+		return
+	}
+	h.Set(TRACE_PARENT_HEADER, s.TraceParent())
+	if len(s.traceState) > 0 {
+		h.Set(TRACE_STATE_HEADER, s.traceState)
+	}
+}
+
+func (s *Span) newChild(name string, kind SpanKind) *Span {
+	return &Span{
+		TraceId:    s.TraceId,
+		SpanId:     newSpanId(),
+		ParentId:   s.SpanId,
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		sampled:    s.sampled,
+		traceState: s.traceState,
+		parent:     s,
+		recorder:   s.recorder,
+	}
+}
+
+//解析traceparent，格式不合法时ok为false
+func ParseTraceParent(value string) (traceId TraceId, spanId SpanId, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	//version 00只有4段，更高版本允许追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return
+	}
+	if _, err := hex.Decode(traceId[:], []byte(parts[1])); err != nil || !traceId.IsValid() {
+		return
+	}
+	if _, err := hex.Decode(spanId[:], []byte(parts[2])); err != nil || !spanId.IsValid() {
+		return
+	}
+	return traceId, spanId, flags[0]&1 == 1, true
+}
+
+func newTraceId() (id TraceId) {
+	rand.Read(id[:])
+	return
+}
+
+func newSpanId() (id SpanId) {
+	rand.Read(id[:])
+	return
+}
+
+//按TRACE_SAMPLE_RATIO采样，由traceid决定，同一trace结果一致
+func sampleTrace(id TraceId) bool {
+	ratio := conf.ApiConf.TRACE_SAMPLE_RATIO
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < ratio
+}
+
+//收集一个请求内采样的span，根span结束时一起导出，之后结束的单独导出
+type spanRecorder struct {
+	root    *Span
+	spans   []*Span
+	flushed bool
+	mu      sync.Mutex
+}
+
+func (r *spanRecorder) finish(s *Span) {
+	if !s.sampled {
+		return
+	}
+	r.mu.Lock()
+	if r.flushed {
+		r.mu.Unlock()
+		exportSpans([]*Span{s})
+		return
+	}
+	r.spans = append(r.spans, s)
+	if s != r.root {
+		r.mu.Unlock()
+		return
+	}
+	spans := r.spans
+	r.spans, r.flushed = nil, true
+	r.mu.Unlock()
+	exportSpans(spans)
+}
+
+//span导出，Export在请求结束时调用，需并发安全
+type SpanExporter interface {
+	Export(spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+var (
+	spanExporter   SpanExporter
+	spanExporterMu sync.RWMutex
+)
+
+//nil关闭导出，未设置时span只用于向下游传递traceparent
+func SetSpanExporter(exporter SpanExporter) {
+	spanExporterMu.Lock()
+	defer spanExporterMu.Unlock()
+	spanExporter = exporter
+}
+
+func getSpanExporter() SpanExporter {
+	spanExporterMu.RLock()
+	defer spanExporterMu.RUnlock()
+	return spanExporter
+}
+
+func exportSpans(spans []*Span) {
+	exporter := getSpanExporter()
+	if exporter == nil || len(spans) == 0 {
+		return
+	}
+	if err := exporter.Export(spans); err != nil {
+		Warn("export spans fail, trace_id:%s, num:%d, err:%v", spans[0].TraceId, len(spans), err)
+	}
+}
+
+func init() {
+	OnShutdown("trace", SHUTDOWN_ORDER_TRACE, func(ctx context.Context) error {
+		if exporter := getSpanExporter(); exporter != nil {
+			return exporter.Shutdown(ctx)
+		}
+		return nil
+	})
+}
+
+//按conf设置导出：TRACE_EXPORTER为otlp_file时写入TRACE_FILE
+func InitTracer() error {
+	switch conf.ApiConf.TRACE_EXPORTER {
+	case "":
+		return nil
+	case TRACE_EXPORTER_OTLP_FILE:
+		exporter, err := NewOtlpFileExporter(conf.ApiConf.TRACE_FILE, conf.ApiConf.TRACE_SERVICE_NAME)
+		if err != nil {
+			return err
+		}
+		SetSpanExporter(exporter)
+		return nil
+	}
+	return fmt.Errorf("unknown trace exporter %s", conf.ApiConf.TRACE_EXPORTER)
+}
+
+//开始请求的根span：header中有合法的traceparent时沿用其trace_id和采样结果，否则按TRACE_SAMPLE_RATIO采样
+func (c *Context) StartTrace(name string, header http.Header) *Span {
+	root := &Span{
+		SpanId: newSpanId(),
+		Name:   name,
+		Kind:   SPAN_KIND_SERVER,
+		Start:  time.Now(),
+	}
+	if traceId, parentId, sampled, ok := ParseTraceParent(header.Get(TRACE_PARENT_HEADER)); ok {
+		root.TraceId, root.ParentId, root.sampled = traceId, parentId, sampled
+		root.traceState = header.Get(TRACE_STATE_HEADER)
+	} else {
+		root.TraceId = newTraceId()
+		root.sampled = sampleTrace(root.TraceId)
+	}
+	root.recorder = &spanRecorder{root: root}
+	if getSpanExporter() == nil {
+		root.recorder = nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.span = root
+	return root
+}
+
+//当前span，即最近一个未结束的StatusStart的span或根span
+func (c *Context) ActiveSpan() *Span {
+	if c == nil {
+		return nil
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return c.span
+}
+
+func (c *Context) TraceId() string {
+	if span := c.ActiveSpan(); span != nil {
+		return span.TraceId.String()
+	}
+	return ""
+}
+
+//以当前span为父节点开始子span，不改变当前span；用于db、redis、rpc等调用，结束时调用End
+func (c *Context) StartSpan(name string, kind SpanKind) *Span {
+	parent := c.ActiveSpan()
+	if parent == nil {
+		return nil
+	}
+	return parent.newChild(name, kind)
+}
+
+//开始子span并设为当前span，之后开始的span以其为父节点
+func (c *Context) pushSpan(name string) *Span {
+	c.Lock()
+	defer c.Unlock()
+	if c.span == nil {
+		return nil
+	}
+	c.span = c.span.newChild(name, SPAN_KIND_INTERNAL)
+	return c.span
+}
+
+//结束pushSpan的span，当前span恢复为其父节点
+func (c *Context) popSpan(span *Span) {
+	if span == nil {
+		return
+	}
+	c.Lock()
+	if c.span == span {
+		c.span = span.parent
+	}
+	c.Unlock()
+	span.End(nil)
+}
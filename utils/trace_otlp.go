@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const TRACE_EXPORTER_OTLP_FILE = "otlp_file"
+
+//OTLP/JSON格式写入文件，每次Export一行ExportTraceServiceRequest，用于本地查看或由collector的filelog接收
+type OtlpFileExporter struct {
+	service string
+	file    *os.File
+	mu      sync.Mutex
+}
+
+//service为resource的service.name，为空时取gomvc
+func NewOtlpFileExporter(path, service string) (*OtlpFileExporter, error) {
+	if len(service) == 0 {
+		service = "gomvc"
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &OtlpFileExporter{service: service, file: f}, nil
+}
+
+func (e *OtlpFileExporter) Export(spans []*Span) error {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, newOtlpSpan(s))
+	}
+	data, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: otlpAnyValue{StringValue: &e.service}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/neil-peng/gomvc"},
+			Spans: otlpSpans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.file == nil {
+		return os.ErrClosed
+	}
+	_, err = e.file.Write(append(data, '\n'))
+	return err
+}
+
+func (e *OtlpFileExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	return err
+}
+
+//OTLP/JSON：id为hex，时间为字符串形式的unix纳秒，int64为字符串
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+//code：0未设置，2失败
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func newOtlpSpan(s *Span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceId:           s.TraceId.String(),
+		SpanId:            s.SpanId.String(),
+		TraceState:        s.traceState,
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.Finish.UnixNano(), 10),
+	}
+	if s.ParentId.IsValid() {
+		span.ParentSpanId = s.ParentId.String()
+	}
+	if len(s.Err) > 0 {
+		span.Status = otlpStatus{Code: 2, Message: s.Err}
+	}
+	keys := make([]string, 0, len(s.Attrs))
+	for k := range s.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: k, Value: newOtlpValue(s.Attrs[k])})
+	}
+	return span
+}
+
+func newOtlpValue(v interface{}) otlpAnyValue {
+	var i int64
+	switch value := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &value}
+	case bool:
+		return otlpAnyValue{BoolValue: &value}
+	case float64:
+		return otlpAnyValue{DoubleValue: &value}
+	case float32:
+		f := float64(value)
+		return otlpAnyValue{DoubleValue: &f}
+	case int:
+		i = int64(value)
+	case int32:
+		i = int64(value)
+	case int64:
+		i = value
+	case uint32:
+		i = int64(value)
+	default:
+		str := fmt.Sprint(value)
+		return otlpAnyValue{StringValue: &str}
+	}
+	str := strconv.FormatInt(i, 10)
+	return otlpAnyValue{IntValue: &str}
+}
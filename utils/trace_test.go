@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+type memExporter struct {
+	batches [][]*Span
+}
+
+func (m *memExporter) Export(spans []*Span) error {
+	m.batches = append(m.batches, spans)
+	return nil
+}
+
+func (m *memExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func TestParseTraceParent(t *testing.T) {
+	traceId, spanId, sampled, ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, traceId.String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, spanId.String(), "00f067aa0ba902b7")
+	assert.Equal(t, sampled, true)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, _, _, ok := ParseTraceParent(value)
+		assert.Equal(t, ok, false, value)
+	}
+	//更高版本允许追加字段
+	_, _, sampled, ok = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, sampled, false)
+}
+
+func traceStep(c *Context) {
+	c.StatusStart()
+	defer c.StatusEnd()
+	c.StartSpan("GET", SPAN_KIND_CLIENT).End(errors.New("redis fail"))
+}
+
+func TestTraceSpans(t *testing.T) {
+	exporter := &memExporter{}
+	SetSpanExporter(exporter)
+	defer SetSpanExporter(nil)
+
+	c := &Context{Logger: NewLogger()}
+	assert.Assert(t, c.StartSpan("no trace", SPAN_KIND_CLIENT) == nil)
+
+	header := http.Header{}
+	header.Set(TRACE_PARENT_HEADER, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set(TRACE_STATE_HEADER, "vendor=1")
+	root := c.StartTrace("GET /test", header)
+	traceStep(c)
+	assert.Equal(t, c.ActiveSpan(), root)
+
+	downstream := http.Header{}
+	c.StartSpan("rpc", SPAN_KIND_CLIENT).Inject(downstream)
+	assert.Assert(t, strings.HasPrefix(downstream.Get(TRACE_PARENT_HEADER), "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+	assert.Assert(t, strings.HasSuffix(downstream.Get(TRACE_PARENT_HEADER), "-01"))
+	assert.Equal(t, downstream.Get(TRACE_STATE_HEADER), "vendor=1")
+
+	assert.Equal(t, len(exporter.batches), 0)
+	root.End(nil)
+	assert.Equal(t, len(exporter.batches), 1)
+	spans := exporter.batches[0]
+	assert.Equal(t, len(spans), 3)
+	redis, step := spans[0], spans[1]
+	assert.Equal(t, redis.Err, "redis fail")
+	assert.Equal(t, redis.ParentId, step.SpanId)
+	assert.Equal(t, step.Name, "traceStep")
+	assert.Equal(t, step.ParentId, root.SpanId)
+	assert.Equal(t, root.ParentId.String(), "00f067aa0ba902b7")
+	assert.Equal(t, c.TraceId(), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	//上游未采样时不导出
+	c = &Context{Logger: NewLogger()}
+	header.Set(TRACE_PARENT_HEADER, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	c.StartTrace("GET /test", header).End(nil)
+	assert.Equal(t, len(exporter.batches), 1)
+}
+
+func TestOtlpFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.json")
+	exporter, err := NewOtlpFileExporter(path, "test")
+	assert.NilError(t, err)
+
+	c := &Context{Logger: NewLogger()}
+	root := c.StartTrace("GET /test", http.Header{})
+	span := c.StartSpan("SELECT db", SPAN_KIND_CLIENT)
+	span.SetAttr("db.system", "mysql")
+	span.SetAttr("rows", 2)
+	span.End(errors.New("timeout"))
+	root.End(nil)
+	assert.NilError(t, exporter.Export([]*Span{span, root}))
+	assert.NilError(t, exporter.Shutdown(context.Background()))
+
+	data, err := ioutil.ReadFile(path)
+	assert.NilError(t, err)
+	var req otlpRequest
+	assert.NilError(t, json.Unmarshal(data, &req))
+	rs := req.ResourceSpans[0]
+	assert.Equal(t, *rs.Resource.Attributes[0].Value.StringValue, "test")
+	spans := rs.ScopeSpans[0].Spans
+	assert.Equal(t, len(spans), 2)
+	assert.Equal(t, spans[0].ParentSpanId, spans[1].SpanId)
+	assert.Equal(t, spans[0].TraceId, root.TraceId.String())
+	assert.Equal(t, spans[0].Kind, SPAN_KIND_CLIENT)
+	assert.Equal(t, spans[0].Status.Code, 2)
+	assert.Equal(t, spans[0].Attributes[0].Key, "db.system")
+	assert.Equal(t, *spans[0].Attributes[1].Value.IntValue, "2")
+	assert.Equal(t, spans[1].ParentSpanId, "")
+	assert.Assert(t, strings.Contains(string(data), `"startTimeUnixNano":"`))
+}